@@ -0,0 +1,173 @@
+package main
+
+import (
+	"ai-agent-app/database"
+	"ai-agent-app/services"
+	"fmt"
+	"strconv"
+)
+
+// runCommand dispatches a command line subcommand
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(args[1:])
+	case "personality":
+		return runPersonalityCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: migrate, personality)", args[0])
+	}
+}
+
+// runMigrateCommand implements "migrate [up [N] | down [N] | status | resize-embeddings |
+// vector-index [rebuild]]". Without arguments every pending migration is applied; "down"
+// reverts one migration by default.
+func runMigrateCommand(args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	steps := 0
+	rebuild := false
+	if len(args) > 1 {
+		if action == "vector-index" {
+			if args[1] != "rebuild" {
+				return fmt.Errorf("unknown vector-index option %q (available: rebuild)", args[1])
+			}
+			rebuild = true
+		} else {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+			steps = n
+		}
+	}
+
+	configureEmbedder()
+	configureVectorIndex()
+	database.InitDB()
+	defer database.CloseDB()
+
+	switch action {
+	case "up":
+		applied, err := database.MigrateUp(steps)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		reverted, err := database.MigrateDown(steps)
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No applied migrations to revert")
+		}
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+	case "status":
+		states, err := database.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			status := "pending"
+			if state.AppliedAt != nil {
+				status = "applied " + state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", state.Version, state.Name, status)
+		}
+	case "resize-embeddings":
+		if err := database.ResizeEmbeddingColumn(); err != nil {
+			return err
+		}
+		fmt.Println("Embedding column resized; existing embeddings were cleared")
+	case "vector-index":
+		if err := database.EnsureVectorIndex(rebuild); err != nil {
+			return err
+		}
+		config := database.GetVectorIndexConfig()
+		fmt.Printf("Vector index is up to date (%s)\n", config.Method)
+	default:
+		return fmt.Errorf("unknown migrate action %q (available: up, down, status, resize-embeddings, vector-index)", action)
+	}
+
+	return nil
+}
+
+// runPersonalityCommand implements "personality import [dir]", which seeds the
+// personalities table from the JSON files in dir (personalities/ by default), and
+// "personality lint [dir]", which validates the files without touching the database
+func runPersonalityCommand(args []string) error {
+	if len(args) == 0 || (args[0] != "import" && args[0] != "lint") {
+		return fmt.Errorf("usage: personality import|lint [dir]")
+	}
+
+	dir := "personalities"
+	if len(args) > 1 {
+		dir = args[1]
+	}
+
+	if args[0] == "lint" {
+		return lintPersonalities(dir)
+	}
+
+	database.InitDB()
+	defer database.CloseDB()
+
+	if err := database.CheckMigrations(false); err != nil {
+		return err
+	}
+
+	imported, err := services.ImportPersonalities(dir)
+	for _, id := range imported {
+		fmt.Printf("Imported %s\n", id)
+	}
+	if err != nil {
+		return err
+	}
+	if len(imported) == 0 {
+		fmt.Printf("No personality files found in %s\n", dir)
+	}
+	return nil
+}
+
+// lintPersonalities prints the problems of every personality file in dir and fails
+// if any file has one
+func lintPersonalities(dir string) error {
+	results, err := services.LintPersonalities(dir)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no personality files found in %s", dir)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if len(result.Problems) == 0 {
+			fmt.Printf("%s: ok\n", result.Path)
+			continue
+		}
+		failed++
+		for _, problem := range result.Problems {
+			fmt.Printf("%s: %s\n", result.Path, problem)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d personality files have problems", failed, len(results))
+	}
+	return nil
+}
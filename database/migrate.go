@@ -0,0 +1,358 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock key that keeps concurrent deployments
+// from running migrations at the same time
+const migrationLockID = 7263450121
+
+// migrationFilePattern matches file names such as 0002_add_index.up.sql
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// embeddingDimension is the vector size of the configured embedder. Migrations create
+// chat_history.embedding with 1536 dimensions; CheckEmbeddingDimension and
+// ResizeEmbeddingColumn bring the column to this size.
+var embeddingDimension = 1536
+
+// SetEmbeddingDimension configures the vector size the embedding column must have. It
+// must match the dimension of the configured embedder.
+func SetEmbeddingDimension(dimension int) {
+	embeddingDimension = dimension
+}
+
+// Migration is one versioned schema change with its up and down scripts
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState reports whether a migration has been applied to the database
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// LoadMigrations returns the embedded migrations ordered by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func MigrationStatus() ([]MigrationState, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			appliedAt := appliedAt
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// PendingMigrations returns the migrations not yet applied to the database. It fails if
+// the database has versions this binary does not know, i.e. it was migrated by a newer release.
+func PendingMigrations() ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]bool, len(migrations))
+	var pending []Migration
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	for version := range applied {
+		if !known[version] {
+			return nil, fmt.Errorf("database has migration %d applied which this build does not know; upgrade the application", version)
+		}
+	}
+
+	return pending, nil
+}
+
+// MigrateUp applies up to steps pending migrations in order (all of them when steps is 0)
+// and returns the ones applied. Each migration runs in its own transaction.
+func MigrateUp(steps int) ([]Migration, error) {
+	var applied []Migration
+	err := withMigrationLock(func(conn *sql.Conn) error {
+		pending, err := PendingMigrations()
+		if err != nil {
+			return err
+		}
+		if steps > 0 && steps < len(pending) {
+			pending = pending[:steps]
+		}
+
+		for _, migration := range pending {
+			err := inTransaction(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and returns them
+func MigrateDown(steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("number of migrations to revert must be positive")
+	}
+
+	var reverted []Migration
+	err := withMigrationLock(func(conn *sql.Conn) error {
+		states, err := MigrationStatus()
+		if err != nil {
+			return err
+		}
+
+		for i := len(states) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := states[i].Migration
+			if states[i].AppliedAt == nil {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+			}
+
+			err := inTransaction(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("Reverted migration %d_%s", migration.Version, migration.Name)
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// CheckMigrations runs at startup. Pending migrations are applied when autoApply is set,
+// otherwise their presence is reported as an error so the deployment can run them explicitly.
+func CheckMigrations(autoApply bool) error {
+	pending, err := PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		log.Println("Database schema is up to date")
+		return nil
+	}
+
+	if !autoApply {
+		return fmt.Errorf("%d pending migration(s), starting with %d_%s; run the migrate command", len(pending), pending[0].Version, pending[0].Name)
+	}
+
+	_, err = MigrateUp(0)
+	return err
+}
+
+// CheckEmbeddingDimension verifies that the chat_history.embedding column holds vectors
+// of the configured dimension, so a changed embedder is caught at startup. A column
+// without stored embeddings, such as the one of a new database, is resized right away
+// since nothing would be discarded.
+func CheckEmbeddingDimension() error {
+	var columnDimension int
+	query := `
+	SELECT atttypmod
+	FROM pg_attribute
+	WHERE attrelid = 'chat_history'::regclass AND attname = 'embedding'`
+	if err := db.QueryRow(query).Scan(&columnDimension); err != nil {
+		return fmt.Errorf("error reading embedding column dimension: %w", err)
+	}
+
+	if columnDimension == embeddingDimension {
+		return nil
+	}
+
+	var embedded bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM chat_history WHERE embedding IS NOT NULL)`).Scan(&embedded); err != nil {
+		return fmt.Errorf("error checking for stored embeddings: %w", err)
+	}
+	if !embedded {
+		return ResizeEmbeddingColumn()
+	}
+
+	return fmt.Errorf("chat_history.embedding holds %d dimensions but the embedder produces %d; "+
+		"run the \"migrate resize-embeddings\" command to switch (existing embeddings are discarded)",
+		columnDimension, embeddingDimension)
+}
+
+// ResizeEmbeddingColumn changes chat_history.embedding to the configured dimension.
+// Stored embeddings cannot be converted and are cleared.
+func ResizeEmbeddingColumn() error {
+	query := fmt.Sprintf(`ALTER TABLE chat_history ALTER COLUMN embedding TYPE vector(%d) USING NULL`, embeddingDimension)
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("error resizing embedding column: %w", err)
+	}
+	log.Printf("Resized chat_history.embedding to %d dimensions", embeddingDimension)
+	return nil
+}
+
+// createMigrationsTable creates the schema_migrations bookkeeping table if it does not exist
+func createMigrationsTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// appliedMigrations returns the applied migration versions with their application time
+func appliedMigrations() (map[int]time.Time, error) {
+	if err := createMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations row: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the migration advisory lock
+func withMigrationLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	return fn(conn)
+}
+
+// inTransaction runs fn in a transaction on conn, committing on success
+func inTransaction(conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
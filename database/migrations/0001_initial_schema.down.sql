@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS chat_history;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS agents;
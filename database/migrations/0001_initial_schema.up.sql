@@ -0,0 +1,36 @@
+-- Baseline schema. Uses IF NOT EXISTS so databases created before migrations
+-- existed are adopted without changes.
+CREATE EXTENSION IF NOT EXISTS vector;
+
+CREATE TABLE IF NOT EXISTS agents (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS conversations (
+	id SERIAL PRIMARY KEY,
+	agent_id INTEGER NOT NULL,
+	owner VARCHAR(255) NOT NULL DEFAULT '',
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (agent_id) REFERENCES agents(id)
+);
+
+CREATE INDEX IF NOT EXISTS conversations_agent_owner_idx ON conversations (agent_id, owner);
+
+CREATE TABLE IF NOT EXISTS chat_history (
+	id SERIAL PRIMARY KEY,
+	agent_id INTEGER NOT NULL,
+	role VARCHAR(50) NOT NULL,
+	content TEXT NOT NULL,
+	embedding vector(1536),
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (agent_id) REFERENCES agents(id)
+);
+
+ALTER TABLE chat_history
+	ADD COLUMN IF NOT EXISTS tool_call_id VARCHAR(255),
+	ADD COLUMN IF NOT EXISTS tool_name VARCHAR(255),
+	ADD COLUMN IF NOT EXISTS conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS chat_history_conversation_idx ON chat_history (conversation_id, created_at);
@@ -0,0 +1,2 @@
+-- Recent history and clears filter chat_history by agent
+CREATE INDEX IF NOT EXISTS chat_history_agent_created_idx ON chat_history (agent_id, created_at);
@@ -0,0 +1,13 @@
+-- Rolling LLM-generated summaries of history that fell out of the prompt window.
+-- conversation_id is NULL for the agent-wide history of messages without a conversation.
+CREATE TABLE IF NOT EXISTS conversation_summaries (
+	id SERIAL PRIMARY KEY,
+	agent_id INTEGER NOT NULL REFERENCES agents(id),
+	conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE,
+	summary TEXT NOT NULL,
+	covered_through_id INTEGER NOT NULL,
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS conversation_summaries_scope_idx
+	ON conversation_summaries (agent_id, (COALESCE(conversation_id, 0)));
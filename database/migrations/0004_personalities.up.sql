@@ -0,0 +1,18 @@
+-- Personalities managed through the API. List fields and the style block are stored
+-- as JSON documents in the same shape as the files under personalities/.
+CREATE TABLE IF NOT EXISTS personalities (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	provider TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	system TEXT NOT NULL DEFAULT '',
+	bio JSONB NOT NULL DEFAULT '[]',
+	lore JSONB NOT NULL DEFAULT '[]',
+	knowledge JSONB NOT NULL DEFAULT '[]',
+	style JSONB NOT NULL DEFAULT '{}',
+	adjectives JSONB NOT NULL DEFAULT '[]',
+	instructions TEXT NOT NULL DEFAULT '',
+	tools JSONB NOT NULL DEFAULT '[]',
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
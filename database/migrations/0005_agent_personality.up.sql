@@ -0,0 +1,3 @@
+-- Explicit binding of agents to a personality. The ID refers to a row of the
+-- personalities table or to a file under personalities/, so it is not a foreign key.
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS personality_id TEXT;
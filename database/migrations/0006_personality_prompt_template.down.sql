@@ -0,0 +1 @@
+ALTER TABLE personalities DROP COLUMN IF EXISTS prompt_template;
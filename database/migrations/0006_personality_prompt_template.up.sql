@@ -0,0 +1,2 @@
+-- Optional per-personality text/template for the system prompt; empty uses the default
+ALTER TABLE personalities ADD COLUMN IF NOT EXISTS prompt_template TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Generation settings of a personality (model, temperature, top_p, max_tokens, stop,
+-- penalties and seed) in the same shape as the personality files
+ALTER TABLE personalities ADD COLUMN IF NOT EXISTS model_params JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+ALTER TABLE agents DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE agents DROP COLUMN IF EXISTS created_at;
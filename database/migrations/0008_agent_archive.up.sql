@@ -0,0 +1,4 @@
+-- Creation time of an agent and the time it was archived. Archived agents keep their
+-- conversations and history but are hidden from listings and cannot chat.
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+-- History pages are read by agent in message ID order
+CREATE INDEX IF NOT EXISTS chat_history_agent_id_idx ON chat_history (agent_id, id);
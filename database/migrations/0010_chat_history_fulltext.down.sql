@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS chat_history_content_tsv_idx;
+ALTER TABLE chat_history DROP COLUMN IF EXISTS content_tsv;
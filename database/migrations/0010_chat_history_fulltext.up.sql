@@ -0,0 +1,5 @@
+-- Full-text index over message content for hybrid retrieval
+ALTER TABLE chat_history ADD COLUMN IF NOT EXISTS content_tsv tsvector
+	GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE INDEX IF NOT EXISTS chat_history_content_tsv_idx ON chat_history USING GIN (content_tsv);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS chat_history_agent_embedded_idx;
@@ -0,0 +1,3 @@
+-- Lets vector searches of small agents and conversations scan only their embedded
+-- messages instead of the approximate nearest neighbour index
+CREATE INDEX IF NOT EXISTS chat_history_agent_embedded_idx ON chat_history (agent_id, conversation_id) WHERE embedding IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS chat_history_missing_embedding_idx;
+ALTER TABLE chat_history DROP COLUMN IF EXISTS embedding_attempts;
@@ -0,0 +1,5 @@
+-- Counts failed embedding attempts so the backfill gives up on messages that never embed.
+-- Messages without an embedding form the durable queue of the embedding pipeline.
+ALTER TABLE chat_history ADD COLUMN IF NOT EXISTS embedding_attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS chat_history_missing_embedding_idx ON chat_history (id) WHERE embedding IS NULL;
@@ -0,0 +1,9 @@
+-- Embeddings by model and SHA-256 hash of the text, shared by every instance so identical
+-- text is embedded once. The vector size is left open because each model has its own.
+CREATE TABLE IF NOT EXISTS embedding_cache (
+	model TEXT NOT NULL,
+	content_hash TEXT NOT NULL,
+	embedding vector NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (model, content_hash)
+);
@@ -50,47 +50,6 @@ func CloseDB() {
 	}
 }
 
-// CreateAgentsTable creates the agents table if it does not exist
-func CreateAgentsTable() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS agents (
-		id SERIAL PRIMARY KEY,
-		name VARCHAR(255) NOT NULL
-	);`
-	_, err := db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("error creating agents table: %w", err)
-	}
-	log.Println("Agents table created or already exists")
-	return nil
-}
-
-// CreateChatHistoryTable creates the chat_history table if it does not exist
-func CreateChatHistoryTable() error {
-	// First, enable the pgvector extension if it's not already enabled
-	_, err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector;")
-	if err != nil {
-		return fmt.Errorf("error creating pgvector extension: %w", err)
-	}
-
-	query := `
-	CREATE TABLE IF NOT EXISTS chat_history (
-		id SERIAL PRIMARY KEY,
-		agent_id INTEGER NOT NULL,
-		role VARCHAR(50) NOT NULL,
-		content TEXT NOT NULL,
-		embedding vector(1536),
-		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (agent_id) REFERENCES agents(id)
-	);`
-	_, err = db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("error creating chat_history table: %w", err)
-	}
-	log.Println("Chat history table created or already exists")
-	return nil
-}
-
 // Exec executes a query without returning any rows
 func Exec(query string, args ...interface{}) (sql.Result, error) {
 	return db.Exec(query, args...)
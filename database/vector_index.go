@@ -0,0 +1,245 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// vectorIndexName is the approximate nearest neighbour index managed on chat_history.embedding
+const vectorIndexName = "chat_history_embedding_idx"
+
+// vectorIndexLockID is the advisory lock key that lets one instance at a time
+// maintain the vector index
+const vectorIndexLockID = 7263450122
+
+// ErrVectorIndexBusy is returned by EnsureVectorIndex while another instance is
+// maintaining the vector index
+var ErrVectorIndexBusy = errors.New("vector index is being maintained by another instance")
+
+// maxIndexedDimension is the largest vector size pgvector can index
+const maxIndexedDimension = 2000
+
+// Vector index methods
+const (
+	VectorIndexHNSW    = "hnsw"
+	VectorIndexIVFFlat = "ivfflat"
+	VectorIndexNone    = "none"
+)
+
+// VectorIndexConfig describes the index on chat_history.embedding and how searches use it
+type VectorIndexConfig struct {
+	Method         string // hnsw, ivfflat or none
+	M              int    // HNSW: links per node
+	EfConstruction int    // HNSW: candidate list size while building
+	Lists          int    // IVFFlat: number of clusters
+	EfSearch       int    // HNSW: candidate list size per query
+	Probes         int    // IVFFlat: clusters visited per query
+	// IterativeScan enables pgvector's iterative index scans (relaxed_order or strict_order,
+	// pgvector 0.8+), which keep scanning until enough rows pass the agent filter
+	IterativeScan string
+	// ExactSearchThreshold is the number of embedded messages up to which a scope is searched
+	// exactly instead of through the index, which is both faster and complete for small agents
+	ExactSearchThreshold int
+}
+
+// DefaultVectorIndexConfig returns pgvector's recommended starting parameters
+func DefaultVectorIndexConfig() VectorIndexConfig {
+	return VectorIndexConfig{
+		Method:               VectorIndexHNSW,
+		M:                    16,
+		EfConstruction:       64,
+		Lists:                100,
+		EfSearch:             40,
+		Probes:               10,
+		ExactSearchThreshold: 10000,
+	}
+}
+
+// vectorIndexConfig is the configuration used by EnsureVectorIndex and vector searches
+var vectorIndexConfig = DefaultVectorIndexConfig()
+
+// SetVectorIndexConfig configures the managed vector index and per-query search settings
+func SetVectorIndexConfig(config VectorIndexConfig) {
+	vectorIndexConfig = config
+}
+
+// GetVectorIndexConfig returns the configured vector index and search settings
+func GetVectorIndexConfig() VectorIndexConfig {
+	return vectorIndexConfig
+}
+
+// VectorIndexConfigFromEnv reads the vector index configuration from VECTOR_INDEX,
+// VECTOR_INDEX_M, VECTOR_INDEX_EF_CONSTRUCTION, VECTOR_INDEX_LISTS, VECTOR_SEARCH_EF_SEARCH,
+// VECTOR_SEARCH_PROBES, VECTOR_SEARCH_ITERATIVE_SCAN and VECTOR_EXACT_SEARCH_THRESHOLD
+func VectorIndexConfigFromEnv() (VectorIndexConfig, error) {
+	config := DefaultVectorIndexConfig()
+	if value := os.Getenv("VECTOR_INDEX"); value != "" {
+		config.Method = value
+	}
+	switch config.Method {
+	case VectorIndexHNSW, VectorIndexIVFFlat, VectorIndexNone:
+	default:
+		return config, fmt.Errorf("unknown VECTOR_INDEX %q (available: hnsw, ivfflat, none)", config.Method)
+	}
+
+	settings := []struct {
+		name   string
+		target *int
+		min    int
+	}{
+		{"VECTOR_INDEX_M", &config.M, 2},
+		{"VECTOR_INDEX_EF_CONSTRUCTION", &config.EfConstruction, 4},
+		{"VECTOR_INDEX_LISTS", &config.Lists, 1},
+		{"VECTOR_SEARCH_EF_SEARCH", &config.EfSearch, 1},
+		{"VECTOR_SEARCH_PROBES", &config.Probes, 1},
+		{"VECTOR_EXACT_SEARCH_THRESHOLD", &config.ExactSearchThreshold, 0},
+	}
+	for _, setting := range settings {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < setting.min {
+			return config, fmt.Errorf("invalid %s %q: must be an integer of at least %d", setting.name, value, setting.min)
+		}
+		*setting.target = parsed
+	}
+	if config.EfConstruction < 2*config.M {
+		return config, fmt.Errorf("VECTOR_INDEX_EF_CONSTRUCTION (%d) must be at least twice VECTOR_INDEX_M (%d)", config.EfConstruction, config.M)
+	}
+
+	config.IterativeScan = os.Getenv("VECTOR_SEARCH_ITERATIVE_SCAN")
+	switch config.IterativeScan {
+	case "", "off", "relaxed_order", "strict_order":
+	default:
+		return config, fmt.Errorf("unknown VECTOR_SEARCH_ITERATIVE_SCAN %q (available: off, relaxed_order, strict_order)", config.IterativeScan)
+	}
+
+	return config, nil
+}
+
+// definition returns the CREATE INDEX statement for the configured method, or an empty
+// string when no index is wanted
+func (c VectorIndexConfig) definition() string {
+	switch c.Method {
+	case VectorIndexHNSW:
+		return fmt.Sprintf(`CREATE INDEX CONCURRENTLY %s ON chat_history USING hnsw (embedding vector_cosine_ops) WITH (m = %d, ef_construction = %d)`,
+			vectorIndexName, c.M, c.EfConstruction)
+	case VectorIndexIVFFlat:
+		return fmt.Sprintf(`CREATE INDEX CONCURRENTLY %s ON chat_history USING ivfflat (embedding vector_cosine_ops) WITH (lists = %d)`,
+			vectorIndexName, c.Lists)
+	}
+	return ""
+}
+
+// signature identifies the build parameters of an index. It is stored as the index
+// comment so a changed configuration is detected.
+func (c VectorIndexConfig) signature() string {
+	switch c.Method {
+	case VectorIndexHNSW:
+		return fmt.Sprintf("hnsw m=%d ef_construction=%d", c.M, c.EfConstruction)
+	case VectorIndexIVFFlat:
+		return fmt.Sprintf("ivfflat lists=%d", c.Lists)
+	}
+	return ""
+}
+
+// SearchSettings returns the SET LOCAL statements that tune a vector search for the
+// configured index method
+func (c VectorIndexConfig) SearchSettings() []string {
+	var settings []string
+	switch c.Method {
+	case VectorIndexHNSW:
+		settings = append(settings, fmt.Sprintf("SET LOCAL hnsw.ef_search = %d", c.EfSearch))
+		if c.IterativeScan != "" {
+			settings = append(settings, fmt.Sprintf("SET LOCAL hnsw.iterative_scan = %s", c.IterativeScan))
+		}
+	case VectorIndexIVFFlat:
+		settings = append(settings, fmt.Sprintf("SET LOCAL ivfflat.probes = %d", c.Probes))
+		if c.IterativeScan != "" {
+			settings = append(settings, fmt.Sprintf("SET LOCAL ivfflat.iterative_scan = %s", c.IterativeScan))
+		}
+	}
+	return settings
+}
+
+// EnsureVectorIndex creates, rebuilds or drops the index on chat_history.embedding so it
+// matches the configuration. Indexes are built concurrently, so writes continue meanwhile,
+// and an index left invalid by an interrupted build is rebuilt. With rebuild set an
+// up-to-date index is rebuilt as well, which refreshes IVFFlat clusters after the
+// history has grown. Only one instance maintains the index at a time; the others get
+// ErrVectorIndexBusy instead of racing to drop and build the same index.
+func EnsureVectorIndex(rebuild bool) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, vectorIndexLockID).Scan(&locked); err != nil {
+		return fmt.Errorf("error acquiring vector index lock: %w", err)
+	}
+	if !locked {
+		return ErrVectorIndexBusy
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, vectorIndexLockID)
+
+	return ensureVectorIndex(ctx, conn, rebuild)
+}
+
+// ensureVectorIndex brings the vector index in line with the configuration on a
+// connection holding the vector index lock
+func ensureVectorIndex(ctx context.Context, conn *sql.Conn, rebuild bool) error {
+	config := vectorIndexConfig
+	wanted := config.signature()
+	if wanted != "" && embeddingDimension > maxIndexedDimension {
+		log.Printf("Warning: %d-dimensional embeddings are too large for a %s index (at most %d); searches scan every message",
+			embeddingDimension, config.Method, maxIndexedDimension)
+		wanted = ""
+	}
+
+	var current sql.NullString
+	var valid bool
+	query := `
+	SELECT obj_description(i.indexrelid, 'pg_class'), i.indisvalid
+	FROM pg_index i
+	JOIN pg_class c ON c.oid = i.indexrelid
+	WHERE c.relname = $1`
+	err := conn.QueryRowContext(ctx, query, vectorIndexName).Scan(&current, &valid)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error reading vector index: %w", err)
+	}
+
+	if exists && valid && current.String == wanted && !rebuild {
+		return nil
+	}
+
+	if exists {
+		log.Printf("Dropping vector index %s (%s)", vectorIndexName, current.String)
+		if _, err := conn.ExecContext(ctx, `DROP INDEX CONCURRENTLY IF EXISTS `+vectorIndexName); err != nil {
+			return fmt.Errorf("error dropping vector index: %w", err)
+		}
+	}
+	if wanted == "" {
+		return nil
+	}
+
+	log.Printf("Building vector index %s (%s); this can take a while on large histories", vectorIndexName, wanted)
+	if _, err := conn.ExecContext(ctx, config.definition()); err != nil {
+		return fmt.Errorf("error creating vector index: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`COMMENT ON INDEX %s IS '%s'`, vectorIndexName, wanted)); err != nil {
+		return fmt.Errorf("error recording vector index parameters: %w", err)
+	}
+	log.Printf("Vector index %s is ready", vectorIndexName)
+	return nil
+}
@@ -4,9 +4,12 @@ import (
 	"ai-agent-app/models"
 	"ai-agent-app/services"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // CreateAgentResponse represents the structure of the create agent response
@@ -18,21 +21,23 @@ type CreateAgentResponse struct {
 func CreateAgent(w http.ResponseWriter, r *http.Request) {
 	var agent models.Agent
 	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
 		log.Printf("Error decoding request body: %v", err)
 		return
 	}
 
 	// Basic validation (you can expand this as needed)
 	if agent.Name == "" {
-		http.Error(w, "Agent name is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Agent name is required")
 		return
 	}
 
+	// New agents start active whatever the payload says
+	agent.ArchivedAt = nil
+
 	// Call the service to save the agent to the database
 	if err := services.CreateAgent(&agent); err != nil {
-		http.Error(w, "Error saving agent to database", http.StatusInternalServerError)
-		log.Printf("Error saving agent: %v", err)
+		writeAgentError(w, err)
 		return
 	}
 
@@ -40,15 +45,199 @@ func CreateAgent(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Agent created: %+v", agent)
 
 	// Send response
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(CreateAgentResponse{Agent: agent})
 }
 
-// CreateDefaultAgent creates a default agent and returns its ID
-func CreateDefaultAgent(agentName string) (int, error) {
+// SetAgentPersonalityRequest represents the structure of a personality binding request
+type SetAgentPersonalityRequest struct {
+	PersonalityID string `json:"personality_id"`
+}
+
+// SetAgentPersonality binds an agent to a personality. An empty personality_id removes
+// the binding, so the agent falls back to matching a personality by its name.
+func SetAgentPersonality(w http.ResponseWriter, r *http.Request) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var requestBody SetAgentPersonalityRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		log.Printf("Error decoding request body: %v", err)
+		return
+	}
+
+	agent, err := services.GetAgentByID(agentID)
+	if err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	agent.PersonalityID = requestBody.PersonalityID
+	if err := services.UpdateAgent(agent); err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	log.Printf("Agent %d bound to personality %q", agent.ID, agent.PersonalityID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
+
+// GetAgent returns a single agent, archived or not
+func GetAgent(w http.ResponseWriter, r *http.Request) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	agent, err := services.GetAgentByID(agentID)
+	if err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
+
+// PatchAgentRequest represents a partial agent update. Fields left out are unchanged;
+// archived set to false restores an archived agent.
+type PatchAgentRequest struct {
+	Name          *string `json:"name"`
+	PersonalityID *string `json:"personality_id"`
+	Archived      *bool   `json:"archived"`
+}
+
+// PatchAgent updates the name, personality binding or archive state of an agent
+func PatchAgent(w http.ResponseWriter, r *http.Request) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var requestBody PatchAgentRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+
+	agent, err := services.GetAgentByID(agentID)
+	if err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	if requestBody.Name != nil {
+		if *requestBody.Name == "" {
+			writeError(w, http.StatusBadRequest, "Agent name cannot be empty")
+			return
+		}
+		agent.Name = *requestBody.Name
+	}
+	if requestBody.PersonalityID != nil {
+		agent.PersonalityID = *requestBody.PersonalityID
+	}
+	if requestBody.Archived != nil {
+		switch {
+		case *requestBody.Archived && agent.ArchivedAt == nil:
+			now := time.Now()
+			agent.ArchivedAt = &now
+		case !*requestBody.Archived:
+			agent.ArchivedAt = nil
+		}
+	}
+
+	if err := services.UpdateAgent(agent); err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	log.Printf("Agent %d updated: %+v", agent.ID, agent)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
+
+// DeleteAgent archives an agent, keeping its conversations and history. With ?purge=true
+// the agent is removed together with its conversations, history and summaries.
+func DeleteAgent(w http.ResponseWriter, r *http.Request) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	purge := false
+	if value := r.URL.Query().Get("purge"); value != "" {
+		if purge, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid purge")
+			return
+		}
+	}
+
+	if !purge {
+		agent, err := services.ArchiveAgent(agentID)
+		if err != nil {
+			writeAgentError(w, err)
+			return
+		}
+		log.Printf("Agent %d archived", agentID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent)
+		return
+	}
+
+	if err := services.DeleteAgent(agentID); err != nil {
+		writeAgentError(w, err)
+		return
+	}
+	// Drop any cached turns so a new agent reusing the ID starts clean
+	WebChatHistory.ClearHistory(services.AgentScope(agentID))
+	log.Printf("Agent %d deleted with its history", agentID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Agent deleted"})
+}
+
+// writeAgentError maps an agent service error to an HTTP status
+func writeAgentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidPersonality):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrNotFound):
+		writeError(w, http.StatusNotFound, "Agent not found")
+	case errors.Is(err, services.ErrConflict):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		log.Printf("Error handling agent: %v", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error handling agent: %v", err))
+	}
+}
+
+// CreateDefaultAgent creates a default agent and returns its ID. Without an explicit
+// personality the agent is bound to the personality whose ID equals its name, if any.
+func CreateDefaultAgent(agentName, personalityID string) (int, error) {
+	if personalityID == "" && agentName != "" {
+		if _, err := services.FindPersonality(agentName); err == nil {
+			personalityID = agentName
+		}
+	}
+
 	// Create a default agent
 	agent := models.Agent{
-		Name: agentName,
+		Name:          agentName,
+		PersonalityID: personalityID,
 	}
 
 	// Call the service to save the agent to the database
@@ -59,13 +248,32 @@ func CreateDefaultAgent(agentName string) (int, error) {
 	return agent.ID, nil
 }
 
-func GetOrCreateDefaultAgent(agentName string) (int, error) {
+// GetOrCreateDefaultAgent returns the agent with the given name, creating it if needed.
+// A non-empty personalityID rebinds an existing agent to that personality, and an
+// archived agent is restored.
+func GetOrCreateDefaultAgent(agentName, personalityID string) (int, error) {
 	// Check if the agent already exists
 	existingAgent, err := services.GetAgentByName(agentName)
 	if err == nil {
+		changed := false
+		if personalityID != "" && existingAgent.PersonalityID != personalityID {
+			existingAgent.PersonalityID = personalityID
+			changed = true
+		}
+		// Asking for an archived agent by name brings it back
+		if existingAgent.ArchivedAt != nil {
+			log.Printf("Restoring archived agent %s", agentName)
+			existingAgent.ArchivedAt = nil
+			changed = true
+		}
+		if changed {
+			if err := services.UpdateAgent(existingAgent); err != nil {
+				return 0, fmt.Errorf("failed to update agent: %w", err)
+			}
+		}
 		return existingAgent.ID, nil
 	}
 
 	// If the agent doesn't exist, create a new one
-	return CreateDefaultAgent(agentName)
+	return CreateDefaultAgent(agentName, personalityID)
 }
@@ -1,19 +1,26 @@
 package handlers
 
 import (
+	"ai-agent-app/models"
 	"ai-agent-app/services"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 // ChatRequest represents the structure of a chat request
 type ChatRequest struct {
-	Message string `json:"message"`
+	Message        string             `json:"message"`
+	ConversationID int                `json:"conversation_id"` // Continue this conversation; 0 starts a new one
+	Owner          string             `json:"owner"`           // Owner recorded on a newly started conversation
+	Options        models.ModelParams `json:"options"`         // Overrides the personality's model parameters for this turn
 }
 
 // WebChatHistory is a global chat history for web requests
@@ -21,39 +28,40 @@ var WebChatHistory = services.NewChatHistory(10)
 
 // ChatWithAgent handles API chat requests with the agent
 func ChatWithAgent(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	agentIDStr := vars["agentID"]
-
-	// Validate and convert agentID to integer
-	if agentIDStr == "" {
-		http.Error(w, "agentID is required", http.StatusBadRequest)
-		return
-	}
-
-	agentID, err := strconv.Atoi(agentIDStr)
+	agentID, err := agentIDFromRequest(r)
 	if err != nil {
-		http.Error(w, "Invalid agent ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Extract the message from the request body
 	var requestBody ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
 		log.Printf("Error decoding request body: %v", err)
 		return
 	}
 
 	// Validate the message
 	if requestBody.Message == "" {
-		http.Error(w, "Message is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Message is required")
+		return
+	}
+	if err := services.ValidateModelParams(requestBody.Options); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid options: %v", err))
+		return
+	}
+
+	conversationID, status, err := resolveConversation(agentID, requestBody)
+	if err != nil {
+		writeError(w, status, err.Error())
 		return
 	}
 
 	// Use the same function as the console chat
-	responseMessage, err := ConsoleChatWithAgent(agentID, requestBody.Message, WebChatHistory)
+	result, err := ConsoleChatWithAgent(r.Context(), agentID, conversationID, requestBody.Message, WebChatHistory, requestBody.Options)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error communicating with agent: %v", err), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error communicating with agent: %v", err))
 		return
 	}
 
@@ -62,37 +70,428 @@ func ChatWithAgent(w http.ResponseWriter, r *http.Request) {
 
 	// Send response
 	response := ChatResponse{
-		Message: responseMessage,
+		Message:        result.Message,
+		ConversationID: conversationID,
+		PromptTokens:   result.PromptTokens,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetAgents returns a list of all available agents
-func GetAgents(w http.ResponseWriter, r *http.Request) {
-	agents, err := services.GetAllAgents()
+// StreamChatWithAgent streams the agent's reply as Server-Sent Events. POST requests carry
+// a ChatRequest body; GET requests (for EventSource clients) pass the message as ?message=
+// and model parameter overrides as JSON in ?options=.
+// Each fragment is sent as a "delta" event, followed by a "done" event with the full reply
+// or an "error" event if generation fails.
+func StreamChatWithAgent(w http.ResponseWriter, r *http.Request) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var requestBody ChatRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		requestBody.Message = query.Get("message")
+		requestBody.Owner = query.Get("owner")
+		if value := query.Get("conversation_id"); value != "" {
+			if requestBody.ConversationID, err = strconv.Atoi(value); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid conversation ID")
+				return
+			}
+		}
+		if value := query.Get("options"); value != "" {
+			decoder := json.NewDecoder(strings.NewReader(value))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&requestBody.Options); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid options: %v", err))
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		log.Printf("Error decoding request body: %v", err)
+		return
+	}
+
+	if requestBody.Message == "" {
+		writeError(w, http.StatusBadRequest, "Message is required")
+		return
+	}
+	if err := services.ValidateModelParams(requestBody.Options); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid options: %v", err))
+		return
+	}
+
+	conversationID, status, err := resolveConversation(agentID, requestBody)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	// Long answers outlive the server's write timeout, so lift it for this response
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Warning: Could not clear write deadline for stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	log.Printf("API stream chat request for agentID: %d, message: %s", agentID, requestBody.Message)
+
+	result, err := ConsoleStreamChatWithAgent(r.Context(), agentID, conversationID, requestBody.Message, WebChatHistory, requestBody.Options, func(delta string) error {
+		return writeEvent(w, flusher, "delta", map[string]string{"content": delta})
+	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving agents: %v", err), http.StatusInternalServerError)
+		log.Printf("Error streaming chat for agentID %d: %v", agentID, err)
+		writeEvent(w, flusher, "error", map[string]string{"error": fmt.Sprintf("Error communicating with agent: %v", err)})
 		return
 	}
 
+	writeEvent(w, flusher, "done", ChatResponse{Message: result.Message, ConversationID: conversationID, PromptTokens: result.PromptTokens})
+}
+
+// writeEvent writes a single Server-Sent Event with a JSON payload and flushes it to the client
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// writeError writes an error as a JSON body of the form {"error": message}
+func writeError(w http.ResponseWriter, status int, message string) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(agents)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
-// ClearAgentHistory clears the chat history for a specific agent
-func ClearAgentHistory(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	agentIDStr := vars["agentID"]
+// resolveConversation returns the conversation a chat request continues. A request without
+// a conversation ID continues the most recent conversation of its owner with the agent, so
+// clients that never pass an ID keep their context; one is started, titled after the
+// message, if the owner has none. Archived agents cannot chat. On failure the HTTP status
+// to report is returned alongside the error.
+func resolveConversation(agentID int, request ChatRequest) (int, int, error) {
+	agent, err := services.GetAgentByID(agentID)
+	if err != nil {
+		return 0, http.StatusNotFound, fmt.Errorf("Agent not found")
+	}
+	if agent.ArchivedAt != nil {
+		return 0, http.StatusConflict, fmt.Errorf("Agent %d is archived", agentID)
+	}
+
+	if request.ConversationID != 0 {
+		conversation, err := services.GetConversationByID(request.ConversationID)
+		if err != nil || conversation.AgentID != agentID {
+			return 0, http.StatusNotFound, fmt.Errorf("Conversation not found")
+		}
+		return conversation.ID, http.StatusOK, nil
+	}
+
+	conversation, err := services.GetOrCreateConversation(agentID, request.Owner, conversationTitle(request.Message))
+	if err != nil {
+		return 0, http.StatusInternalServerError, fmt.Errorf("Error resolving conversation: %v", err)
+	}
+	return conversation.ID, http.StatusOK, nil
+}
+
+// conversationTitle derives a short conversation title from its first message
+func conversationTitle(message string) string {
+	const maxTitleLength = 60
+	title := []rune(strings.TrimSpace(message))
+	if len(title) > maxTitleLength {
+		return string(title[:maxTitleLength]) + "..."
+	}
+	return string(title)
+}
+
+// agentIDFromRequest extracts and validates the agentID path variable
+func agentIDFromRequest(r *http.Request) (int, error) {
+	agentIDStr := mux.Vars(r)["agentID"]
+	if agentIDStr == "" {
+		return 0, fmt.Errorf("agentID is required")
+	}
 
 	agentID, err := strconv.Atoi(agentIDStr)
 	if err != nil {
-		http.Error(w, "Invalid agent ID", http.StatusBadRequest)
+		return 0, fmt.Errorf("Invalid agent ID")
+	}
+
+	return agentID, nil
+}
+
+// Page sizes for paginated listings
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// AgentListResponse represents a page of agents together with the total number of matches
+type AgentListResponse struct {
+	Agents []models.Agent `json:"agents"`
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+// GetAgents returns a page of agents. The name (substring, case-insensitive), personality_id
+// and include_archived query parameters filter the list; limit and offset page through it.
+func GetAgents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := services.AgentFilter{
+		Name:          query.Get("name"),
+		PersonalityID: query.Get("personality_id"),
+		Limit:         defaultPageSize,
+	}
+
+	var err error
+	if value := query.Get("include_archived"); value != "" {
+		if filter.IncludeArchived, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid include_archived")
+			return
+		}
+	}
+	if filter.Limit, err = pageLimit(query); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if value := query.Get("offset"); value != "" {
+		if filter.Offset, err = strconv.Atoi(value); err != nil || filter.Offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	agents, total, err := services.ListAgents(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error retrieving agents: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AgentListResponse{
+		Agents: agents,
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	})
+}
+
+// pageLimit reads the limit query parameter, defaulting to defaultPageSize
+func pageLimit(query url.Values) (int, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	return limit, nil
+}
+
+// GetAgentHistory returns a page of an agent's chat history, newest first unless ?order=asc.
+// Pass the next_cursor of a page as ?cursor= to read the following page. The history can be
+// narrowed to one conversation with ?conversation_id=, to roles with ?role= (repeated or
+// comma-separated) and to a time range with ?since= and ?until= (RFC 3339).
+func GetAgentHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter services.HistoryFilter
+	var err error
+
+	if filter.Limit, err = pageLimit(query); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		writeError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+	if value := query.Get("cursor"); value != "" {
+		if filter.AfterID, err = strconv.Atoi(value); err != nil || filter.AfterID < 1 {
+			writeError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+	messageFilter, err := messageFilterFromQuery(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Roles, filter.Since, filter.Until = messageFilter.Roles, messageFilter.Since, messageFilter.Until
+
+	scope, status, err := historyScopeFromRequest(r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	page, err := WebChatHistory.GetHistoryPage(scope, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// Result counts for memory searches
+const (
+	defaultMemoryResults = 5
+	maxMemoryResults     = 50
+)
+
+// MemorySearchResponse represents the messages an agent recalls for a query
+type MemorySearchResponse struct {
+	Query   string                   `json:"query"`
+	Results []services.ScoredMessage `json:"results"`
+}
+
+// SearchAgentMemory returns the agent's messages most relevant to ?q=, the same hybrid
+// retrieval that feeds memories into its prompts. ?k= sets the number of results and
+// ?vector_weight= and ?lexical_weight= override the fusion weights; ?conversation_id=,
+// ?role=, ?since= and ?until= narrow the search as for GetAgentHistory.
+func SearchAgentMemory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	text := strings.TrimSpace(query.Get("q"))
+	if text == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	filter, err := messageFilterFromQuery(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Limit = defaultMemoryResults
+	if value := query.Get("k"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > maxMemoryResults {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("k must be between 1 and %d", maxMemoryResults))
+			return
+		}
+	}
+
+	scope, status, err := historyScopeFromRequest(r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	weights := services.DefaultRetrievalWeights()
+	for name, target := range map[string]*float64{"vector_weight": &weights.Vector, "lexical_weight": &weights.Lexical} {
+		if value := query.Get(name); value != "" {
+			if *target, err = strconv.ParseFloat(value, 64); err != nil || *target < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("%s must be a non-negative number", name))
+				return
+			}
+		}
+	}
+	if weights.Vector == 0 && weights.Lexical == 0 {
+		writeError(w, http.StatusBadRequest, "vector_weight and lexical_weight cannot both be 0")
+		return
+	}
+
+	results, err := WebChatHistory.SearchMemories(scope, text, filter, weights)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error searching memory: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MemorySearchResponse{Query: text, Results: results})
+}
+
+// messageFilterFromQuery reads the role (repeated or comma-separated), since and until
+// (RFC 3339) query parameters
+func messageFilterFromQuery(query url.Values) (services.SearchFilter, error) {
+	var filter services.SearchFilter
+	for _, value := range query["role"] {
+		for _, role := range strings.Split(value, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				filter.Roles = append(filter.Roles, role)
+			}
+		}
+	}
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			var err error
+			if *target, err = time.Parse(time.RFC3339, value); err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+		}
+	}
+	return filter, nil
+}
+
+// historyScopeFromRequest returns the history scope named by the agentID path variable and
+// the optional conversation_id query parameter, checking that the agent and conversation
+// exist. On failure the HTTP status to report is returned alongside the error.
+func historyScopeFromRequest(r *http.Request) (services.HistoryScope, int, error) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		return services.HistoryScope{}, http.StatusBadRequest, err
+	}
+
+	scope := services.AgentScope(agentID)
+	if value := r.URL.Query().Get("conversation_id"); value != "" {
+		if scope.ConversationID, err = strconv.Atoi(value); err != nil {
+			return scope, http.StatusBadRequest, fmt.Errorf("Invalid conversation ID")
+		}
+	}
+
+	if _, err := services.GetAgentByID(agentID); err != nil {
+		return scope, http.StatusNotFound, fmt.Errorf("Agent not found")
+	}
+	if scope.ConversationID != 0 {
+		conversation, err := services.GetConversationByID(scope.ConversationID)
+		if err != nil || conversation.AgentID != agentID {
+			return scope, http.StatusNotFound, fmt.Errorf("Conversation not found")
+		}
+	}
+	return scope, http.StatusOK, nil
+}
+
+// ClearAgentHistory clears the chat history of one conversation of an agent, given as
+// ?conversation_id=. Clearing every conversation of the agent takes an explicit ?all=true.
+func ClearAgentHistory(w http.ResponseWriter, r *http.Request) {
+	scope, status, err := historyScopeFromRequest(r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	if scope.ConversationID == 0 && !all {
+		writeError(w, http.StatusBadRequest, "conversation_id is required; pass all=true to clear every conversation of the agent")
+		return
+	}
+	if scope.ConversationID != 0 && all {
+		writeError(w, http.StatusBadRequest, "conversation_id and all=true cannot be combined")
 		return
 	}
 
-	WebChatHistory.ClearHistory(agentID)
+	WebChatHistory.ClearHistory(scope)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Chat history cleared"})
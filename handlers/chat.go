@@ -2,130 +2,63 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os"
-	"strings"
 
+	"ai-agent-app/models"
 	"ai-agent-app/services" // Import the services package
+	"ai-agent-app/services/tools"
 )
 
+// maxToolRounds limits how many rounds of tool calls a single chat turn may take
+const maxToolRounds = 5
+
 // ChatResponse represents the structure of the chat response
 type ChatResponse struct {
-	Message string `json:"message"`
+	Message        string `json:"message"`
+	ConversationID int    `json:"conversation_id,omitempty"`
+	PromptTokens   int    `json:"prompt_tokens,omitempty"`
+}
+
+// ChatResult is the outcome of a chat turn
+type ChatResult struct {
+	Message      string
+	PromptTokens int // Estimated size of the prompt sent to the model
+}
+
+// ConsoleChatWithAgent handles chat interactions from the console. History and memory
+// search are limited to the given conversation of the agent. Cancelling ctx abandons
+// the request to the model.
+func ConsoleChatWithAgent(ctx context.Context, agentID, conversationID int, message string, chatHistory *services.ChatHistory, overrides models.ModelParams) (ChatResult, error) {
+	return chatWithAgent(ctx, agentID, conversationID, message, chatHistory, overrides, nil)
 }
 
-// Global chat history for web requests
-// var webChatHistory = services.NewChatHistory(10)
-
-// ChatWithAgent handles chat requests with the agent
-// func ChatWithAgent(w http.ResponseWriter, r *http.Request) {
-// 	vars := mux.Vars(r)
-// 	agentIDStr := vars["agentID"]
-
-// 	// Validate and convert agentID to integer
-// 	if agentIDStr == "" {
-// 		http.Error(w, "agentID is required", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	agentID, err := strconv.Atoi(agentIDStr)
-// 	if err != nil {
-// 		http.Error(w, "Invalid agent ID", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	// Extract the message from the request body
-// 	var requestBody struct {
-// 		Message string `json:"message"`
-// 	}
-// 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-// 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-// 		log.Printf("Error decoding request body: %v", err)
-// 		return
-// 	}
-
-// 	// Validate the message
-// 	if requestBody.Message == "" {
-// 		http.Error(w, "Message is required", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	// Add user message to history
-// 	webChatHistory.AddMessage(agentID, "user", requestBody.Message)
-
-// 	// Get the conversation history
-// 	history := webChatHistory.GetHistory(agentID)
-
-// 	// Define the context template with conversation history
-// 	template := `You are {{name}}, an AI assistant.
-// {{description}}
-// You specialize in {{specialty}}.
-// Communication style: {{style}}
-// Follow these rules:
-// {{rules}}
-
-// Previous conversation:
-// {{history}}
-
-// Current request:
-// `
-
-// 	agent, err := services.GetAgentByID(agentID)
-// 	// Create state map for context variables
-// 	state := map[string]string{
-// 		"name":        agent.Name,
-// 		"description": agent.Description,
-// 		"specialty":   agent.System,
-// 		"style":       "professional and helpful",
-// 		"rules":       "1. Be concise\n2. Be accurate\n3. Be helpful",
-// 		"history":     formatHistory(history), // You'll need to implement this
-// 	}
-
-// 	// Use the OpenAI API to generate a response
-// 	responseMessage, err := services.SendMessageToOpenAI(
-// 		os.Getenv("OPENAI_API_KEY"),
-// 		requestBody.Message,
-// 		template,
-// 		state,
-// 	)
-
-// 	if err != nil {
-// 		http.Error(w, "Error communicating with the agent", http.StatusInternalServerError)
-// 		log.Printf("Error communicating with agent %d: %v", agentID, err)
-// 		return
-// 	}
-
-// 	// Add the assistant's response to history
-// 	webChatHistory.AddMessage(agentID, "assistant", responseMessage)
-
-// 	// Log the chat request
-// 	log.Printf("Chat request for agentID: %d, message: %s", agentID, requestBody.Message)
-
-// 	// Send response
-// 	response := ChatResponse{
-// 		Message: responseMessage,
-// 	}
-
-// 	w.Header().Set("Content-Type", "application/json")
-// 	json.NewEncoder(w).Encode(response)
-// }
-
-// ConsoleChatWithAgent handles chat interactions from the console
-func ConsoleChatWithAgent(agentID int, message string, chatHistory *services.ChatHistory) (string, error) {
+// ConsoleStreamChatWithAgent behaves like ConsoleChatWithAgent but relays the reply to
+// onDelta as it is generated. The full reply is stored in history once complete.
+func ConsoleStreamChatWithAgent(ctx context.Context, agentID, conversationID int, message string, chatHistory *services.ChatHistory, overrides models.ModelParams, onDelta func(delta string) error) (ChatResult, error) {
+	return chatWithAgent(ctx, agentID, conversationID, message, chatHistory, overrides, onDelta)
+}
+
+// chatWithAgent runs one chat turn. The personality's model parameters apply with
+// overrides on top. When onDelta is nil the reply is requested in one piece, otherwise
+// it is streamed through onDelta.
+func chatWithAgent(ctx context.Context, agentID, conversationID int, message string, chatHistory *services.ChatHistory, overrides models.ModelParams, onDelta func(delta string) error) (ChatResult, error) {
+	scope := services.ConversationScope(agentID, conversationID)
+
 	// Create channels for our goroutine results
 	historyChan := make(chan []services.Message, 1)
 	similarMessagesChan := make(chan []services.Message, 1)
 
 	// Start goroutine to get chat history
 	go func() {
-		history := chatHistory.GetHistory(agentID)
+		history := chatHistory.GetHistory(scope)
 		historyChan <- history
 	}()
 
 	// Start goroutine to search for similar messages
 	go func() {
-		similar, err := chatHistory.SearchSimilarMessages(agentID, message, 3)
+		similar, err := chatHistory.SearchSimilarMessages(scope, message, 3)
 		if err != nil {
 			log.Printf("Warning: Could not search for similar messages: %v", err)
 			similarMessagesChan <- []services.Message{} // Empty slice instead of nil
@@ -138,113 +71,141 @@ func ConsoleChatWithAgent(agentID int, message string, chatHistory *services.Cha
 	history := <-historyChan
 	similarMessages := <-similarMessagesChan
 
-	// The rest of your existing code remains unchanged
 	agent, err := services.GetAgentByID(agentID)
 	if err != nil {
-		return "", fmt.Errorf("error retrieving agent %d: %v", agentID, err)
+		return ChatResult{}, fmt.Errorf("error retrieving agent %d: %v", agentID, err)
+	}
+	if agent.ArchivedAt != nil {
+		return ChatResult{}, fmt.Errorf("agent %d is archived", agentID)
 	}
 
-	personality, err := services.LoadPersonality(agent.Name)
+	personality, err := services.LoadAgentPersonality(agent)
 	if err != nil {
-		return "", fmt.Errorf("error loading personality: %w", err)
+		return ChatResult{}, fmt.Errorf("error loading personality: %w", err)
 	}
 
-	template := `You are {{name}}, an AI assistant.
-		{{description}}
-		{{system}}
-		
-		Background:
-		{{bio}}
-		
-		Experience:
-		{{lore}}
-		
-		Expertise:
-		{{knowledge}}
-		
-		Communication style:
-		{{style}}
-		
-		Instructions:
-		{{instructions}}
-		
-		Recent chat history:
-		{{history}}
-		
-		Relevant past conversations:
-		{{similarMessages}}
-		
-		Adjectives:
-		{{adjectives}}
-		
-		Instructions:
-		{{instructions}}
-		
-		`
-
-	// Create state map for context variables
-	state := map[string]string{
-		"name":            personality.Name,
-		"description":     personality.Description,
-		"specialty":       personality.System,
-		"history":         formatChatHistory(history),
-		"style":           strings.Join(personality.Style.Chat, "\n"),
-		"bio":             strings.Join(personality.Bio, "\n"),
-		"lore":            strings.Join(personality.Lore, "\n"),
-		"knowledge":       strings.Join(personality.Knowledge, "\n"),
-		"adjectives":      strings.Join(personality.Adjectives, "\n"),
-		"instructions":    personality.Instructions,
-		"similarMessages": formatChatHistory(similarMessages),
+	// Resolve the backend this personality runs on
+	provider, err := services.GetProvider(personality.Provider)
+	if err != nil {
+		return ChatResult{}, fmt.Errorf("error selecting provider for agent %d: %w", agentID, err)
 	}
 
-	// Add similar messages if available
-	if len(similarMessages) > 0 {
-		state["similar_messages"] = formatChatHistory(similarMessages)
+	// Look up the tools this personality is allowed to call
+	toolDefinitions, err := tools.Definitions(personality.Tools)
+	if err != nil {
+		return ChatResult{}, fmt.Errorf("error loading tools for agent %d: %w", agentID, err)
 	}
 
-	// Use the OpenAI API to generate a response
-	responseMessage, err := services.SendMessageToOpenAI(
-		os.Getenv("OPENAI_API_KEY"),
-		message,
-		template,
-		state,
-	)
-
+	// Fit the persona, summary, memories and recent turns into the model's context window
+	input := services.PromptInput{
+		Personality: personality,
+		Summary:     chatHistory.GetSummary(scope),
+		Memories:    similarMessages,
+		History:     history,
+		Message:     message,
+		Tools:       toolDefinitions,
+	}
+	params := personality.ModelParams.Merge(overrides)
+	model := provider.Model()
+	if params.Model != "" {
+		model = params.Model
+	}
+	prompt, err := services.NewPromptAssembler(model, params.MaxTokens).Assemble(input)
 	if err != nil {
-		return "", fmt.Errorf("error communicating with agent %d: %v", agentID, err)
+		return ChatResult{}, err
 	}
+	log.Printf("Prompt for agent %d: %d of %d tokens (history %d/%d turns, memories %d/%d, persona %d/%d items)",
+		agentID, prompt.Tokens, prompt.ContextWindow,
+		prompt.Sections["history"].Kept, prompt.Sections["history"].Kept+prompt.Sections["history"].Dropped,
+		prompt.Sections["memories"].Kept, prompt.Sections["memories"].Kept+prompt.Sections["memories"].Dropped,
+		prompt.Sections["persona"].Kept, prompt.Sections["persona"].Kept+prompt.Sections["persona"].Dropped)
 
-	// Add the message to history with embedding
-	if err := chatHistory.AddMessage(agentID, "user", message); err != nil {
-		log.Printf("Warning: Could not add user message to history: %v", err)
-	}
+	// Messages produced during this turn, stored once the final answer is in
+	transcript := []services.Message{{Role: "user", Content: message}}
 
-	// Add the response to history with embedding
-	if err := chatHistory.AddMessage(agentID, "assistant", responseMessage); err != nil {
-		log.Printf("Warning: Could not add assistant response to history: %v", err)
+	// Execute requested tools and feed their results back until the model answers
+	responseMessage, toolMessages, err := completeWithTools(ctx, agentID, provider, prompt.Messages, params, toolDefinitions, personality.Tools, onDelta)
+	if err != nil {
+		return ChatResult{}, err
+	}
+	transcript = append(transcript, toolMessages...)
+	transcript = append(transcript, services.Message{Role: "assistant", Content: responseMessage})
+
+	// Add the turn to history with embeddings, tool calls and results under their own roles
+	for _, msg := range transcript {
+		var err error
+		if msg.ToolName != "" {
+			err = chatHistory.AddToolMessage(scope, msg.Role, msg.ToolCallID, msg.ToolName, msg.Content)
+		} else {
+			err = chatHistory.AddMessage(scope, msg.Role, msg.Content)
+		}
+		if err != nil {
+			log.Printf("Warning: Could not add %s message to history: %v", msg.Role, err)
+		}
 	}
 
+	// Fold history that left the recent window into the summary once it grows too long
+	chatHistory.UpdateSummaryAsync(scope, provider)
+
 	// Log the console chat request
 	log.Printf("Console chat request for agentID: %d, message: %s", agentID, message)
 
-	return responseMessage, nil
+	return ChatResult{Message: responseMessage, PromptTokens: prompt.Tokens}, nil
 }
 
-// formatHistory converts the chat history array to a formatted string
-func formatHistory(history []services.Message) string {
-	if len(history) == 0 {
-		return "No previous conversation."
-	}
+// completeWithTools asks the provider for a reply, executing the tools it requests and
+// feeding their results back. Tools are withheld on the last of maxToolRounds rounds so
+// the model has to answer; tool calls it still makes then are dropped. Besides the answer
+// it returns the messages produced on the way: intermediate text, tool calls and results.
+func completeWithTools(ctx context.Context, agentID int, provider services.ChatProvider, messages []services.ChatMessage, params models.ModelParams, toolDefinitions []tools.Definition, allowedTools []string, onDelta func(delta string) error) (string, []services.Message, error) {
+	var transcript []services.Message
+	for round := 0; ; round++ {
+		request := services.CompletionRequest{Messages: messages, Params: params}
+		if round < maxToolRounds {
+			request.Tools = toolDefinitions
+		}
 
-	var formattedHistory string
-	for _, msg := range history {
-		formattedHistory += fmt.Sprintf("%s: %s\n", msg.Role, msg.Content)
-	}
+		var completion *services.CompletionResponse
+		var err error
+		if onDelta != nil {
+			completion, err = provider.Stream(ctx, request, onDelta)
+		} else {
+			completion, err = provider.Complete(ctx, request)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("error communicating with agent %d: %v", agentID, err)
+		}
 
-	return formattedHistory
-}
+		if len(completion.ToolCalls) == 0 {
+			return completion.Content, transcript, nil
+		}
+		if round >= maxToolRounds {
+			if completion.Content == "" {
+				return "", nil, fmt.Errorf("agent %d kept calling tools after %d rounds without answering", agentID, maxToolRounds)
+			}
+			log.Printf("Warning: Agent %d requested %d tool calls after %d rounds, ignoring them", agentID, len(completion.ToolCalls), maxToolRounds)
+			return completion.Content, transcript, nil
+		}
+
+		messages = append(messages, services.ChatMessage{Role: "assistant", Content: completion.Content, ToolCalls: completion.ToolCalls})
+		if completion.Content != "" {
+			transcript = append(transcript, services.Message{Role: "assistant", Content: completion.Content})
+		}
 
-// formatChatHistory is an alias for formatHistory for consistency
-func formatChatHistory(history []services.Message) string {
-	return formatHistory(history)
+		for _, call := range completion.ToolCalls {
+			log.Printf("Agent %d calling tool %s with arguments %s", agentID, call.Name, call.Arguments)
+
+			result, err := tools.Execute(call, allowedTools)
+			if err != nil {
+				log.Printf("Warning: Tool %s failed: %v", call.Name, err)
+				result = fmt.Sprintf("Error: %v", err)
+			}
+
+			messages = append(messages, services.ChatMessage{Role: "tool", Content: result, ToolCallID: call.ID, Name: call.Name})
+			transcript = append(transcript,
+				services.Message{Role: "tool_call", Content: call.Arguments, ToolCallID: call.ID, ToolName: call.Name},
+				services.Message{Role: "tool", Content: result, ToolCallID: call.ID, ToolName: call.Name},
+			)
+		}
+	}
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"ai-agent-app/models"
+	"ai-agent-app/services"
+	"ai-agent-app/services/tools"
+)
+
+// toolLoopProvider asks for a tool call on every completion, even when no tools are offered
+type toolLoopProvider struct {
+	content       string
+	calls         int
+	callsWithTool int
+}
+
+func (p *toolLoopProvider) Name() string  { return "fake" }
+func (p *toolLoopProvider) Model() string { return "fake" }
+
+func (p *toolLoopProvider) Complete(ctx context.Context, req services.CompletionRequest) (*services.CompletionResponse, error) {
+	p.calls++
+	if len(req.Tools) > 0 {
+		p.callsWithTool++
+	}
+	return &services.CompletionResponse{
+		Content:   p.content,
+		ToolCalls: []tools.Call{{ID: "call", Name: "get_current_time", Arguments: "{}"}},
+	}, nil
+}
+
+func (p *toolLoopProvider) Stream(ctx context.Context, req services.CompletionRequest, onDelta func(delta string) error) (*services.CompletionResponse, error) {
+	return p.Complete(ctx, req)
+}
+
+func TestCompleteWithToolsStopsAfterMaxRounds(t *testing.T) {
+	messages := []services.ChatMessage{{Role: "user", Content: "what time is it?"}}
+	definitions, err := tools.Definitions([]string{"get_current_time"})
+	if err != nil {
+		t.Fatalf("Definitions: %v", err)
+	}
+
+	t.Run("without content", func(t *testing.T) {
+		provider := &toolLoopProvider{}
+		_, _, err := completeWithTools(context.Background(), 1, provider, messages, models.ModelParams{}, definitions, []string{"get_current_time"}, nil)
+		if err == nil || !strings.Contains(err.Error(), "kept calling tools") {
+			t.Fatalf("error = %v, want the tool loop to be cut off", err)
+		}
+		if provider.calls != maxToolRounds+1 {
+			t.Errorf("provider called %d times, want %d", provider.calls, maxToolRounds+1)
+		}
+		if provider.callsWithTool != maxToolRounds {
+			t.Errorf("tools offered %d times, want %d", provider.callsWithTool, maxToolRounds)
+		}
+	})
+
+	t.Run("with content", func(t *testing.T) {
+		provider := &toolLoopProvider{content: "It is noon."}
+		answer, transcript, err := completeWithTools(context.Background(), 1, provider, messages, models.ModelParams{}, definitions, []string{"get_current_time"}, nil)
+		if err != nil {
+			t.Fatalf("completeWithTools: %v", err)
+		}
+		if answer != "It is noon." {
+			t.Errorf("answer = %q, want the content of the last round", answer)
+		}
+		if provider.calls != maxToolRounds+1 {
+			t.Errorf("provider called %d times, want %d", provider.calls, maxToolRounds+1)
+		}
+		// Each executed round records its text, the tool call and the tool result
+		if len(transcript) != 3*maxToolRounds {
+			t.Errorf("transcript has %d messages, want %d", len(transcript), 3*maxToolRounds)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"ai-agent-app/models"
+	"ai-agent-app/services"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateConversationRequest represents the structure of a create conversation request
+type CreateConversationRequest struct {
+	Owner string `json:"owner"`
+	Title string `json:"title"`
+}
+
+// ConversationResponse represents a conversation together with its messages
+type ConversationResponse struct {
+	Conversation models.Conversation `json:"conversation"`
+	Messages     []services.Message  `json:"messages"`
+}
+
+// CreateConversation starts a new conversation with an agent
+func CreateConversation(w http.ResponseWriter, r *http.Request) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var requestBody CreateConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		log.Printf("Error decoding request body: %v", err)
+		return
+	}
+
+	if _, err := services.GetAgentByID(agentID); err != nil {
+		writeError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+
+	conversation := models.Conversation{
+		AgentID: agentID,
+		Owner:   requestBody.Owner,
+		Title:   requestBody.Title,
+	}
+	if err := services.CreateConversation(&conversation); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error creating conversation: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// ListConversations returns the conversations of an agent, optionally filtered by ?owner=
+func ListConversations(w http.ResponseWriter, r *http.Request) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conversations, err := services.ListConversations(agentID, r.URL.Query().Get("owner"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error retrieving conversations: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// GetConversation returns a conversation and its full message history
+func GetConversation(w http.ResponseWriter, r *http.Request) {
+	conversation, status, err := conversationFromRequest(r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	scope := services.ConversationScope(conversation.AgentID, conversation.ID)
+	response := ConversationResponse{
+		Conversation: *conversation,
+		Messages:     WebChatHistory.GetFullHistory(scope),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteConversation deletes a conversation and its messages, leaving the agent's
+// other conversations untouched
+func DeleteConversation(w http.ResponseWriter, r *http.Request) {
+	conversation, status, err := conversationFromRequest(r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	if err := services.DeleteConversation(conversation.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error deleting conversation: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Conversation deleted"})
+}
+
+// conversationFromRequest loads the conversation named by the agentID and conversationID
+// path variables, making sure it belongs to that agent
+func conversationFromRequest(r *http.Request) (*models.Conversation, int, error) {
+	agentID, err := agentIDFromRequest(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	conversationID, err := strconv.Atoi(mux.Vars(r)["conversationID"])
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid conversation ID")
+	}
+
+	conversation, err := services.GetConversationByID(conversationID)
+	if err != nil || conversation.AgentID != agentID {
+		return nil, http.StatusNotFound, fmt.Errorf("Conversation not found")
+	}
+
+	return conversation, http.StatusOK, nil
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"ai-agent-app/models"
+	"ai-agent-app/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ListPersonalities returns all personalities stored in the database
+func ListPersonalities(w http.ResponseWriter, r *http.Request) {
+	personalities, err := services.ListPersonalities()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error retrieving personalities: %v", err))
+		return
+	}
+	if personalities == nil {
+		personalities = []models.Personality{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(personalities)
+}
+
+// GetLoadedPersonalities describes the personality files currently loaded from disk,
+// with the version and hash of the set
+func GetLoadedPersonalities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services.Personalities().Info())
+}
+
+// CreatePersonality stores a new personality
+func CreatePersonality(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	personality, err := services.DecodePersonality(data, "request")
+	if err != nil {
+		writePersonalityError(w, "", err)
+		return
+	}
+
+	if err := services.CreatePersonality(personality); err != nil {
+		writePersonalityError(w, personality.ID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(personality)
+}
+
+// GetPersonality returns a single personality
+func GetPersonality(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["personalityID"]
+
+	personality, err := services.GetPersonalityByID(id)
+	if err != nil {
+		writePersonalityError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(personality)
+}
+
+// UpdatePersonality replaces a personality. The ID is taken from the path.
+func UpdatePersonality(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["personalityID"]
+
+	// The body may leave out the ID, which is taken from the path
+	var document map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&document); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		log.Printf("Error decoding request body: %v", err)
+		return
+	}
+	if bodyID, ok := document["id"]; ok && bodyID != id {
+		writeError(w, http.StatusBadRequest, "Personality ID cannot be changed")
+		return
+	}
+	document["id"] = id
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	personality, err := services.DecodePersonality(data, "request")
+	if err != nil {
+		writePersonalityError(w, id, err)
+		return
+	}
+
+	if err := services.UpdatePersonality(personality); err != nil {
+		writePersonalityError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(personality)
+}
+
+// DeletePersonality removes a personality
+func DeletePersonality(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["personalityID"]
+
+	if err := services.DeletePersonality(id); err != nil {
+		writePersonalityError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Personality deleted"})
+}
+
+// writePersonalityError maps errors of the personality services to HTTP status codes
+func writePersonalityError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidPersonality):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrNotFound):
+		writeError(w, http.StatusNotFound, "Personality not found")
+	case errors.Is(err, services.ErrConflict):
+		writeError(w, http.StatusConflict, fmt.Sprintf("Personality %s already exists", id))
+	default:
+		log.Printf("Error handling personality %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error handling personality: %v", err))
+	}
+}
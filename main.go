@@ -3,14 +3,17 @@ package main
 import (
 	"ai-agent-app/database"
 	"ai-agent-app/handlers"
+	"ai-agent-app/models"
 	"ai-agent-app/services"
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -30,34 +33,166 @@ func init() {
 }
 
 func main() {
+	// Subcommands such as "migrate" run instead of the chat application
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
 	fmt.Println("AI Agent Application")
 	fmt.Println("-------------------")
 
-	// Initialize database connection
-	database.InitDB()
-	defer database.CloseDB()
+	configureEmbedder()
+	configureVectorIndex()
 
-	// Create necessary tables
-	if err := database.CreateAgentsTable(); err != nil {
-		log.Fatalf("Failed to create agents table: %v", err)
-	}
-	if err := database.CreateChatHistoryTable(); err != nil {
-		log.Fatalf("Failed to create chat history table: %v", err)
-	}
-
-	// For debugging - print the API key (remove in production)
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		log.Println("Warning: OPENAI_API_KEY is not set")
+	// STORAGE_BACKEND=memory runs without Postgres; nothing is persisted
+	if os.Getenv("STORAGE_BACKEND") == "memory" {
+		log.Println("Using in-memory storage, data will be lost on exit")
+		services.UseStores(services.NewMemoryStores())
 	} else {
-		log.Println("OPENAI_API_KEY is set")
+		// Initialize database connection
+		database.InitDB()
+		defer database.CloseDB()
+
+		// Bring the schema up to date, or refuse to start on pending migrations
+		// when AUTO_MIGRATE=false so deployments can run them explicitly
+		if err := database.CheckMigrations(os.Getenv("AUTO_MIGRATE") != "false"); err != nil {
+			log.Fatalf("Database schema check failed: %v", err)
+		}
+		if err := database.CheckEmbeddingDimension(); err != nil {
+			log.Fatalf("Database schema check failed: %v", err)
+		}
+
+		// Index builds on large histories take a while, so they run in the background;
+		// searches scan exactly until the index is ready. With several replicas only one
+		// of them maintains the index.
+		go func() {
+			err := database.EnsureVectorIndex(false)
+			switch {
+			case errors.Is(err, database.ErrVectorIndexBusy):
+				log.Printf("Vector index is being maintained by another instance, skipping")
+			case err != nil:
+				log.Printf("Warning: Could not update vector index: %v", err)
+			}
+		}()
 	}
 
+	configureEmbeddingCache()
+	watchPersonalities()
+	startEmbeddingPipeline()
+
 	// Start HTTP server in a goroutine
 	go startHTTPServer()
 
 	// Start console interface
 	startConsoleInterface()
+
+	stopEmbeddingPipeline()
+}
+
+// configureEmbedder selects the embedder from the environment and sizes the
+// embedding column of new databases to match it
+func configureEmbedder() {
+	embedder, err := services.NewEmbedderFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid embedding configuration: %v", err)
+	}
+	services.UseEmbedder(embedder)
+	database.SetEmbeddingDimension(embedder.Dimension())
+	log.Printf("Using embedding model %s with %d dimensions", embedder.Model(), embedder.Dimension())
+}
+
+// configureVectorIndex reads the vector index and search settings from the environment
+func configureVectorIndex() {
+	config, err := database.VectorIndexConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid vector index configuration: %v", err)
+	}
+	database.SetVectorIndexConfig(config)
+}
+
+// configureEmbeddingCache keeps the last EMBEDDING_CACHE_SIZE embeddings (default 10000,
+// 0 disables the cache) in memory, backed by the embedding_cache table when
+// EMBEDDING_CACHE_DB=true and the database is in use
+func configureEmbeddingCache() {
+	size := services.DefaultEmbeddingCacheSize
+	if value := os.Getenv("EMBEDDING_CACHE_SIZE"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Fatalf("Invalid EMBEDDING_CACHE_SIZE %q", value)
+		}
+		size = parsed
+	}
+
+	var store services.EmbeddingCacheStore
+	if os.Getenv("EMBEDDING_CACHE_DB") == "true" && os.Getenv("STORAGE_BACKEND") != "memory" {
+		store = services.NewPostgresEmbeddingCacheStore()
+	}
+	if size == 0 && store == nil {
+		return
+	}
+
+	services.UseEmbedder(services.NewCachingEmbedder(services.CurrentEmbedder(), size, store))
+	log.Printf("Caching up to %d embeddings in memory (database cache: %t)", size, store != nil)
+}
+
+// startEmbeddingPipeline embeds new messages in background workers and starts the backfill
+// of messages left without an embedding, every EMBEDDING_BACKFILL_INTERVAL (default 5m,
+// 0 disables the backfill)
+func startEmbeddingPipeline() {
+	config, err := services.EmbeddingPipelineConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid embedding pipeline configuration: %v", err)
+	}
+	services.UseEmbeddingPipeline(services.NewEmbeddingPipeline(config))
+
+	interval := 5 * time.Minute
+	if value := os.Getenv("EMBEDDING_BACKFILL_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid EMBEDDING_BACKFILL_INTERVAL %q: %v", value, err)
+		}
+		interval = parsed
+	}
+
+	if interval > 0 {
+		go services.StartEmbeddingBackfill(interval, config.BatchSize, config.MaxAttempts, nil)
+	}
+}
+
+// stopEmbeddingPipeline gives queued messages up to 10 seconds to be embedded
+func stopEmbeddingPipeline() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := services.StopEmbeddingPipeline(ctx); err != nil {
+		log.Printf("Warning: %v; the backfill will embed the remaining messages", err)
+		return
+	}
+	log.Println("Embedding queue drained")
+}
+
+// watchPersonalities loads the personality files and polls them for changes every
+// PERSONALITY_RELOAD_INTERVAL (default 2s, 0 disables reloading)
+func watchPersonalities() {
+	interval := 2 * time.Second
+	if value := os.Getenv("PERSONALITY_RELOAD_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid PERSONALITY_RELOAD_INTERVAL %q: %v", value, err)
+		}
+		interval = parsed
+	}
+
+	registry := services.Personalities()
+	info := registry.Info()
+	log.Printf("Loaded %d personalities (version %d, hash %s)", len(info.Personalities), info.Version, info.Hash)
+
+	if interval > 0 {
+		go registry.Watch(interval, nil)
+	}
 }
 
 func startHTTPServer() {
@@ -66,8 +201,26 @@ func startHTTPServer() {
 	// API routes
 	api := r.PathPrefix("/api").Subrouter()
 	api.HandleFunc("/agents", handlers.GetAgents).Methods("GET")
+	api.HandleFunc("/agents", handlers.CreateAgent).Methods("POST")
+	api.HandleFunc("/agents/{agentID}", handlers.GetAgent).Methods("GET")
+	api.HandleFunc("/agents/{agentID}", handlers.PatchAgent).Methods("PATCH")
+	api.HandleFunc("/agents/{agentID}", handlers.DeleteAgent).Methods("DELETE")
+	api.HandleFunc("/agents/{agentID}/personality", handlers.SetAgentPersonality).Methods("PUT")
 	api.HandleFunc("/agents/{agentID}/chat", handlers.ChatWithAgent).Methods("POST")
+	api.HandleFunc("/agents/{agentID}/chat/stream", handlers.StreamChatWithAgent).Methods("GET", "POST")
+	api.HandleFunc("/agents/{agentID}/history", handlers.GetAgentHistory).Methods("GET")
 	api.HandleFunc("/agents/{agentID}/history", handlers.ClearAgentHistory).Methods("DELETE")
+	api.HandleFunc("/agents/{agentID}/memory/search", handlers.SearchAgentMemory).Methods("GET")
+	api.HandleFunc("/agents/{agentID}/conversations", handlers.CreateConversation).Methods("POST")
+	api.HandleFunc("/agents/{agentID}/conversations", handlers.ListConversations).Methods("GET")
+	api.HandleFunc("/agents/{agentID}/conversations/{conversationID}", handlers.GetConversation).Methods("GET")
+	api.HandleFunc("/agents/{agentID}/conversations/{conversationID}", handlers.DeleteConversation).Methods("DELETE")
+	api.HandleFunc("/personalities", handlers.ListPersonalities).Methods("GET")
+	api.HandleFunc("/personalities", handlers.CreatePersonality).Methods("POST")
+	api.HandleFunc("/personalities/loaded", handlers.GetLoadedPersonalities).Methods("GET")
+	api.HandleFunc("/personalities/{personalityID}", handlers.GetPersonality).Methods("GET")
+	api.HandleFunc("/personalities/{personalityID}", handlers.UpdatePersonality).Methods("PUT")
+	api.HandleFunc("/personalities/{personalityID}", handlers.DeletePersonality).Methods("DELETE")
 
 	// Get port from environment or use default
 	port := os.Getenv("PORT")
@@ -105,6 +258,10 @@ func startHTTPServer() {
 	// Doesn't block if no connections, but will otherwise wait until the timeout
 	srv.Shutdown(ctx)
 	log.Println("HTTP server shutdown gracefully")
+
+	// Finish embedding the messages of the last requests before exiting
+	stopEmbeddingPipeline()
+	os.Exit(0)
 }
 
 func startConsoleInterface() {
@@ -114,14 +271,21 @@ func startConsoleInterface() {
 	// Prompt for agent name
 	agentName := promptForAgentName()
 
-	// Create default agent with the provided name
-	agentID, err := handlers.GetOrCreateDefaultAgent(agentName)
+	// Create default agent with the provided name, bound to AGENT_PERSONALITY if set
+	agentID, err := handlers.GetOrCreateDefaultAgent(agentName, os.Getenv("AGENT_PERSONALITY"))
 	if err != nil {
 		log.Fatalf("Failed to create default agent: %v", err)
 	}
 
 	log.Printf("Created agent with ID: %d and name: %s", agentID, agentName)
 
+	// The console keeps one ongoing conversation per agent across restarts
+	conversation, err := services.GetOrCreateConversation(agentID, "console", "Console session")
+	if err != nil {
+		log.Fatalf("Failed to open console conversation: %v", err)
+	}
+	scope := services.ConversationScope(agentID, conversation.ID)
+
 	fmt.Println("Start chatting with the agent (type 'exit' to quit, 'clear' to clear history):")
 	fmt.Println("API server is running in the background.")
 
@@ -138,19 +302,23 @@ func startConsoleInterface() {
 		}
 
 		if strings.ToLower(userInput) == "clear" {
-			chatHistory.ClearHistory(agentID)
+			chatHistory.ClearHistory(scope)
 			fmt.Println("Chat history cleared.")
 			continue
 		}
 
 		// Chat with the agent - the handler will manage the chat history
-		response, err := handlers.ConsoleChatWithAgent(agentID, userInput, chatHistory)
+		// and the reply is printed token by token as it is generated
+		fmt.Print("Agent: ")
+		_, err := handlers.ConsoleStreamChatWithAgent(context.Background(), agentID, conversation.ID, userInput, chatHistory, models.ModelParams{}, func(delta string) error {
+			fmt.Print(delta)
+			return nil
+		})
+		fmt.Println()
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 			continue
 		}
-
-		fmt.Printf("Agent: %s\n", response)
 	}
 
 	if err := scanner.Err(); err != nil {
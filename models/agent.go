@@ -1,6 +1,11 @@
 package models
 
+import "time"
+
 type Agent struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID            int        `json:"id"`
+	Name          string     `json:"name"`
+	PersonalityID string     `json:"personality_id,omitempty"` // Personality the agent speaks as; empty falls back to matching by name
+	CreatedAt     time.Time  `json:"created_at"`
+	ArchivedAt    *time.Time `json:"archived_at,omitempty"` // Set once the agent is archived; archived agents keep their history but cannot chat
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Conversation is a single chat thread between a user and an agent
+type Conversation struct {
+	ID        int       `json:"id"`
+	AgentID   int       `json:"agent_id"`
+	Owner     string    `json:"owner"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,5 +1,12 @@
 package models
 
+import _ "embed"
+
+// PersonalitySchema is the JSON Schema personality files and API payloads must satisfy
+//
+//go:embed personality.schema.json
+var PersonalitySchema []byte
+
 type Personality struct {
 	ID          string   `json:"id"`
 	Name        string   `json:"name"`
@@ -12,7 +19,52 @@ type Personality struct {
 	Style       struct {
 		All  []string `json:"all"`
 		Chat []string `json:"chat"`
+		Post []string `json:"post"`
 	} `json:"style"`
-	Adjectives   []string `json:"adjectives"`
-	Instructions string   `json:"instructions"`
+	Adjectives     []string `json:"adjectives"`
+	Instructions   string   `json:"instructions"`
+	Tools          []string `json:"tools"`                     // Names of registered tools the agent may call
+	PromptTemplate string   `json:"prompt_template,omitempty"` // text/template for the system prompt; empty uses the default
+	ModelParams             // Generation settings, written inline in personality files
+}
+
+// ModelParams tunes how replies are generated. Unset fields use the provider's defaults.
+type ModelParams struct {
+	Model            string   `json:"model,omitempty"` // Overrides the provider's configured model
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+// Merge returns the parameters with every field set in override replacing its own
+func (p ModelParams) Merge(override ModelParams) ModelParams {
+	if override.Model != "" {
+		p.Model = override.Model
+	}
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		p.MaxTokens = override.MaxTokens
+	}
+	if override.Stop != nil {
+		p.Stop = override.Stop
+	}
+	if override.PresencePenalty != nil {
+		p.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		p.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.Seed != nil {
+		p.Seed = override.Seed
+	}
+	return p
 }
@@ -2,85 +2,104 @@
 package services
 
 import (
-	"ai-agent-app/database"
 	"ai-agent-app/models"
+	"errors"
 	"fmt"
-	"log"
+	"time"
 )
 
-// CreateAgent saves a new agent to the database and returns its ID
+// CreateAgent saves a new agent and sets its ID. Names must be unique, and a
+// personality binding must name an existing personality.
 func CreateAgent(agent *models.Agent) error {
-	// Prepare the SQL statement with RETURNING clause to get the generated ID
-	query := `INSERT INTO agents (name) VALUES ($1) RETURNING id`
-	err := database.GetDB().QueryRow(query,
-		agent.Name,
-	).Scan(&agent.ID)
+	if err := checkAgentName(agent); err != nil {
+		return err
+	}
+	if err := validatePersonalityBinding(agent); err != nil {
+		return err
+	}
+	return stores.Agents.Create(agent)
+}
 
+// UpdateAgent saves the name, personality binding and archive time of an existing agent
+func UpdateAgent(agent *models.Agent) error {
+	if err := checkAgentName(agent); err != nil {
+		return err
+	}
+	if err := validatePersonalityBinding(agent); err != nil {
+		return err
+	}
+	return stores.Agents.Update(agent)
+}
+
+// checkAgentName returns ErrConflict if another agent already uses the agent's name,
+// since the console looks agents up by name
+func checkAgentName(agent *models.Agent) error {
+	existing, err := stores.Agents.GetByName(agent.Name)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
 	if err != nil {
-		log.Printf("Error saving agent to database: %v", err)
 		return err
 	}
+	if existing.ID != agent.ID {
+		return fmt.Errorf("agent name %q: %w", agent.Name, ErrConflict)
+	}
 	return nil
 }
 
-// GetAgentByID retrieves an agent from the database by its ID
-func GetAgentByID(id int) (*models.Agent, error) {
-	query := `SELECT id, name FROM agents WHERE id = $1`
-
-	var agent models.Agent
-	err := database.GetDB().QueryRow(query, id).Scan(
-		&agent.ID,
-		&agent.Name,
-	)
-
-	if err != nil {
-		log.Printf("Error retrieving agent with ID %d: %v", id, err)
-		return nil, err
+// validatePersonalityBinding checks that the personality an agent is bound to exists
+func validatePersonalityBinding(agent *models.Agent) error {
+	if agent.PersonalityID == "" {
+		return nil
+	}
+	if _, err := FindPersonality(agent.PersonalityID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("%w: personality %q does not exist", ErrInvalidPersonality, agent.PersonalityID)
+		}
+		return err
 	}
+	return nil
+}
 
-	return &agent, nil
+// GetAgentByID retrieves an agent by its ID
+func GetAgentByID(id int) (*models.Agent, error) {
+	return stores.Agents.GetByID(id)
 }
 
+// GetAgentByName retrieves an agent by its name
 func GetAgentByName(name string) (*models.Agent, error) {
-	query := `SELECT id, name FROM agents WHERE name = $1`
-
-	var agent models.Agent
-	err := database.GetDB().QueryRow(query, name).Scan(
-		&agent.ID,
-		&agent.Name,
-	)
-
-	if err != nil {
-		log.Printf("Error retrieving agent with name %s: %v", name, err)
-		return nil, err
-	}
-
-	return &agent, nil
+	return stores.Agents.GetByName(name)
 }
 
-// GetAllAgents returns all agents from the database
+// GetAllAgents returns all agents that are not archived
 func GetAllAgents() ([]models.Agent, error) {
-	query := `SELECT id, name FROM agents ORDER BY id`
+	agents, _, err := stores.Agents.List(AgentFilter{})
+	return agents, err
+}
 
-	db := database.GetDB()
-	rows, err := db.Query(query)
+// ListAgents returns a page of the agents matching the filter and the total number of matches
+func ListAgents(filter AgentFilter) ([]models.Agent, int, error) {
+	return stores.Agents.List(filter)
+}
+
+// ArchiveAgent hides an agent from listings and stops it from chatting while keeping its
+// conversations and history. Archiving an archived agent keeps the original time.
+func ArchiveAgent(id int) (*models.Agent, error) {
+	agent, err := stores.Agents.GetByID(id)
 	if err != nil {
-		return nil, fmt.Errorf("error querying agents: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
-
-	var agents []models.Agent
-	for rows.Next() {
-		var agent models.Agent
-		if err := rows.Scan(&agent.ID, &agent.Name); err != nil {
-			return nil, fmt.Errorf("error scanning agent row: %w", err)
+	if agent.ArchivedAt == nil {
+		now := time.Now()
+		agent.ArchivedAt = &now
+		if err := stores.Agents.Update(agent); err != nil {
+			return nil, err
 		}
-		agents = append(agents, agent)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating agent rows: %w", err)
 	}
+	return agent, nil
+}
 
-	return agents, nil
+// DeleteAgent removes an agent together with its conversations, history and summaries
+func DeleteAgent(id int) error {
+	return stores.Agents.Delete(id)
 }
@@ -0,0 +1,357 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai-agent-app/services/tools"
+)
+
+// AnthropicAPIURL is the endpoint for the Anthropic Messages API
+const AnthropicAPIURL = "https://api.anthropic.com/v1/messages"
+
+// AnthropicVersion is the API version sent with every request
+const AnthropicVersion = "2023-06-01"
+
+// DefaultAnthropicModel is used when ANTHROPIC_MODEL is not set
+const DefaultAnthropicModel = "claude-3-5-sonnet-latest"
+
+// anthropicMaxTokens is sent when max_tokens is not set, since the Messages API requires it
+const anthropicMaxTokens = 1024
+
+// AnthropicRequest represents the structure of a request to the Anthropic Messages API
+type AnthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []AnthropicMessage `json:"messages"`
+	Tools         []AnthropicTool    `json:"tools,omitempty"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   float64            `json:"temperature"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+// AnthropicMessage is a chat turn in the Messages API format
+type AnthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+// AnthropicContentBlock is one block of a message: text, a tool_use request or a tool_result
+type AnthropicContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// AnthropicTool declares a callable tool to the model
+type AnthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+// AnthropicResponse represents the structure of a response from the Anthropic Messages API
+type AnthropicResponse struct {
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+// AnthropicStreamEvent represents one server-sent event of a streamed Messages API reply
+type AnthropicStreamEvent struct {
+	Type         string                `json:"type"`
+	Index        int                   `json:"index"`
+	ContentBlock AnthropicContentBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicProvider talks to the Anthropic Messages API
+type anthropicProvider struct {
+	apiKey string
+	model  string
+}
+
+// NewAnthropicProvider creates a provider for the Anthropic Messages API
+func NewAnthropicProvider(apiKey, model string) ChatProvider {
+	if model == "" {
+		model = DefaultAnthropicModel
+	}
+	return &anthropicProvider{apiKey: apiKey, model: model}
+}
+
+// Name returns the provider identifier
+func (p *anthropicProvider) Name() string {
+	return "anthropic"
+}
+
+// Model returns the model requests are sent to
+func (p *anthropicProvider) Model() string {
+	return p.model
+}
+
+// Complete sends the messages to the Messages API and returns the reply
+func (p *anthropicProvider) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	// Start timing
+	startTime := time.Now()
+	log.Printf("Starting anthropic API request...")
+
+	httpReq, err := p.newRequest(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the request
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check the response status code
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	// Parse the response
+	var response AnthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	// Concatenate the text blocks of the reply and collect tool requests
+	var content strings.Builder
+	var toolCalls []tools.Call
+	for _, block := range response.Content {
+		switch block.Type {
+		case "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			toolCalls = append(toolCalls, tools.Call{ID: block.ID, Name: block.Name, Arguments: string(block.Input)})
+		}
+	}
+	if content.Len() == 0 && len(toolCalls) == 0 {
+		return nil, fmt.Errorf("no text content returned")
+	}
+
+	log.Printf("anthropic API request completed in %v", time.Since(startTime))
+
+	return &CompletionResponse{Content: content.String(), ToolCalls: toolCalls}, nil
+}
+
+// Stream sends the messages with streaming enabled, calling onDelta for every text
+// fragment as it arrives. The returned response carries the complete reply.
+func (p *anthropicProvider) Stream(ctx context.Context, req CompletionRequest, onDelta func(delta string) error) (*CompletionResponse, error) {
+	startTime := time.Now()
+	log.Printf("Starting anthropic streaming API request...")
+
+	// Give up on the upstream when it stalls or the caller goes away
+	watchdog := newStreamWatchdog(ctx, streamIdleTimeout)
+	defer watchdog.stop()
+
+	httpReq, err := p.newRequest(watchdog.ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := streamClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", watchdog.err(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	// Relay each text delta and accumulate the full reply and any tool calls
+	var content strings.Builder
+	var toolCalls []tools.Call
+	toolCallIndex := make(map[int]int) // content block index -> position in toolCalls
+	err = readServerSentEvents(watchdog.body(resp.Body), func(event, data string) error {
+		var streamEvent AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &streamEvent); err != nil {
+			return fmt.Errorf("error decoding stream event: %w", err)
+		}
+
+		switch streamEvent.Type {
+		case "content_block_start":
+			if streamEvent.ContentBlock.Type == "tool_use" {
+				toolCallIndex[streamEvent.Index] = len(toolCalls)
+				toolCalls = append(toolCalls, tools.Call{ID: streamEvent.ContentBlock.ID, Name: streamEvent.ContentBlock.Name})
+			}
+		case "content_block_delta":
+			if streamEvent.Delta.Type == "input_json_delta" {
+				if position, ok := toolCallIndex[streamEvent.Index]; ok {
+					toolCalls[position].Arguments += streamEvent.Delta.PartialJSON
+				}
+				return nil
+			}
+			if streamEvent.Delta.Type != "text_delta" || streamEvent.Delta.Text == "" {
+				return nil
+			}
+			content.WriteString(streamEvent.Delta.Text)
+			return onDelta(streamEvent.Delta.Text)
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			return fmt.Errorf("stream error: %s", streamEvent.Error.Message)
+		}
+		return nil
+	})
+	if err != nil && err != errStreamDone {
+		return nil, watchdog.err(err)
+	}
+
+	log.Printf("anthropic streaming API request completed in %v", time.Since(startTime))
+
+	return &CompletionResponse{Content: content.String(), ToolCalls: toolCalls}, nil
+}
+
+// newRequest builds the HTTP request for a Messages API call
+func (p *anthropicProvider) newRequest(ctx context.Context, req CompletionRequest, stream bool) (*http.Request, error) {
+	if p.apiKey == "" {
+		return nil, fmt.Errorf("ANTHROPIC_API_KEY environment variable is not set")
+	}
+
+	// The Messages API takes the system prompt as a top-level field
+	system, messages := splitSystemMessages(req.Messages)
+	requestBody := AnthropicRequest{
+		Model:         req.model(p.model),
+		System:        system,
+		Messages:      toAnthropicMessages(messages),
+		MaxTokens:     anthropicMaxTokens,
+		Temperature:   req.temperature(),
+		TopP:          req.Params.TopP,
+		StopSequences: req.Params.Stop,
+		Stream:        stream,
+	}
+	if req.Params.MaxTokens != nil {
+		requestBody.MaxTokens = *req.Params.MaxTokens
+	}
+	// The Messages API has no penalties or seed; they only apply to OpenAI-compatible backends
+	for _, def := range req.Tools {
+		requestBody.Tools = append(requestBody.Tools, AnthropicTool{
+			Name:        def.Name,
+			Description: def.Description,
+			InputSchema: def.Parameters,
+		})
+	}
+
+	// Convert the request payload to JSON
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	// Create the HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", AnthropicAPIURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	// Set the headers
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", AnthropicVersion)
+
+	return httpReq, nil
+}
+
+// splitSystemMessages separates system messages from the conversation turns.
+// System messages are joined into a single prompt.
+func splitSystemMessages(messages []ChatMessage) (string, []ChatMessage) {
+	var system []string
+	var turns []ChatMessage
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		turns = append(turns, msg)
+	}
+	return strings.Join(system, "\n\n"), turns
+}
+
+// anthropicOmittedTurn opens conversations whose history window starts with a reply,
+// since the Messages API requires the first turn to come from the user
+const anthropicOmittedTurn = "(Earlier conversation omitted.)"
+
+// toAnthropicMessages converts chat messages to Messages API turns. Tool results become
+// tool_result blocks in a user turn, and consecutive messages of the same role are merged.
+// Tool results whose call fell out of the history window are dropped, and a placeholder
+// user turn is put first when the conversation would otherwise start with the assistant.
+func toAnthropicMessages(messages []ChatMessage) []AnthropicMessage {
+	var converted []AnthropicMessage
+	toolUses := make(map[string]bool)
+	for _, msg := range messages {
+		role := msg.Role
+		var blocks []AnthropicContentBlock
+
+		switch msg.Role {
+		case "tool":
+			role = "user"
+			if !toolUses[msg.ToolCallID] {
+				continue
+			}
+			blocks = append(blocks, AnthropicContentBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content})
+		default:
+			if msg.Content != "" {
+				blocks = append(blocks, AnthropicContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				toolUses[call.ID] = true
+				blocks = append(blocks, AnthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: anthropicToolInput(call)})
+			}
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+		if last := len(converted) - 1; last >= 0 && converted[last].Role == role {
+			converted[last].Content = append(converted[last].Content, blocks...)
+			continue
+		}
+		converted = append(converted, AnthropicMessage{Role: role, Content: blocks})
+	}
+
+	if len(converted) > 0 && converted[0].Role != "user" {
+		placeholder := AnthropicMessage{Role: "user", Content: []AnthropicContentBlock{{Type: "text", Text: anthropicOmittedTurn}}}
+		converted = append([]AnthropicMessage{placeholder}, converted...)
+	}
+	return converted
+}
+
+// anthropicToolInput returns the arguments of a tool call as a tool_use input. The input
+// must be a JSON object; anything else, such as arguments cut off mid-stream, is replaced
+// by an empty object so it cannot break the whole request.
+func anthropicToolInput(call tools.Call) json.RawMessage {
+	input := bytes.TrimSpace([]byte(call.Arguments))
+	if len(input) == 0 {
+		return json.RawMessage("{}")
+	}
+	if input[0] != '{' || !json.Valid(input) {
+		log.Printf("Warning: Tool call %s to %s has invalid arguments, sending none: %q", call.ID, call.Name, call.Arguments)
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(input)
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"ai-agent-app/services/tools"
+)
+
+func TestToAnthropicMessagesStartsWithUser(t *testing.T) {
+	converted := toAnthropicMessages([]ChatMessage{
+		{Role: "tool", ToolCallID: "dropped", Content: "result of a call outside the window"},
+		{Role: "assistant", Content: "an earlier reply"},
+		{Role: "user", Content: "hello"},
+	})
+
+	if len(converted) != 3 {
+		t.Fatalf("got %d turns, want 3: %+v", len(converted), converted)
+	}
+	if converted[0].Role != "user" || converted[0].Content[0].Text != anthropicOmittedTurn {
+		t.Errorf("first turn = %+v, want the placeholder user turn", converted[0])
+	}
+	if converted[1].Role != "assistant" || converted[2].Role != "user" {
+		t.Errorf("roles = %s, %s, want assistant, user", converted[1].Role, converted[2].Role)
+	}
+}
+
+func TestToAnthropicMessagesToolCalls(t *testing.T) {
+	converted := toAnthropicMessages([]ChatMessage{
+		{Role: "user", Content: "what time is it?"},
+		{Role: "assistant", ToolCalls: []tools.Call{
+			{ID: "a", Name: "get_current_time", Arguments: `{"timezone": "UTC"}`},
+			{ID: "b", Name: "get_current_time", Arguments: `{"timezone": "UT`},
+			{ID: "c", Name: "get_current_time", Arguments: `[1, 2]`},
+			{ID: "d", Name: "get_current_time"},
+		}},
+		{Role: "tool", ToolCallID: "a", Content: "12:00"},
+		{Role: "tool", ToolCallID: "b", Content: "error"},
+	})
+
+	if len(converted) != 3 {
+		t.Fatalf("got %d turns, want 3: %+v", len(converted), converted)
+	}
+	wantInputs := []string{`{"timezone": "UTC"}`, `{}`, `{}`, `{}`}
+	for i, block := range converted[1].Content {
+		if string(block.Input) != wantInputs[i] {
+			t.Errorf("tool_use %s input = %s, want %s", block.ID, block.Input, wantInputs[i])
+		}
+	}
+	if results := converted[2].Content; len(results) != 2 || results[0].Type != "tool_result" {
+		t.Errorf("tool results = %+v, want both merged into one user turn", results)
+	}
+
+	request := AnthropicRequest{Model: "test", Messages: converted}
+	if _, err := json.Marshal(request); err != nil {
+		t.Errorf("request with invalid tool arguments does not marshal: %v", err)
+	}
+}
@@ -1,200 +1,237 @@
 package services
 
 import (
-	"ai-agent-app/database"
-	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 )
 
 // Message represents a single message in the chat history
 type Message struct {
-	ID        int       `json:"id"`
-	Role      string    `json:"role"`    // "user" or "assistant"
-	Content   string    `json:"content"` // The message content
-	Embedding []float32 `json:"-"`       // The embedding vector (not included in JSON)
+	ID         int       `json:"id"`
+	Role       string    `json:"role"`                   // "user", "assistant", "tool_call" or "tool"
+	Content    string    `json:"content"`                // The message content
+	ToolCallID string    `json:"tool_call_id,omitempty"` // Links a tool result to the call it answers
+	ToolName   string    `json:"tool_name,omitempty"`    // The tool that was called
+	CreatedAt  time.Time `json:"created_at"`             // When the message was stored
+	Embedding  []float32 `json:"-"`                      // The embedding vector (not included in JSON)
+}
+
+// HistoryScope selects the part of the chat history an operation applies to: all
+// messages of an agent, or only those of one of its conversations
+type HistoryScope struct {
+	AgentID        int
+	ConversationID int // 0 selects every message of the agent
+}
+
+// AgentScope returns a scope covering every message of an agent
+func AgentScope(agentID int) HistoryScope {
+	return HistoryScope{AgentID: agentID}
+}
+
+// ConversationScope returns a scope covering a single conversation of an agent
+func ConversationScope(agentID, conversationID int) HistoryScope {
+	return HistoryScope{AgentID: agentID, ConversationID: conversationID}
 }
 
 // ChatHistory stores conversation history for each agent
 type ChatHistory struct {
-	contextSize int // Number of messages to include in context
+	contextSize        int          // Number of messages to include in context
+	summaryTokenBudget int          // Overrides SUMMARY_TOKEN_BUDGET when set
+	messages           MessageStore // Overrides the active message store when set
+	vectors            VectorStore  // Overrides the active vector store when set
+	summaries          SummaryStore // Overrides the active summary store when set
 }
 
-// NewChatHistory creates a new chat history manager
+// NewChatHistory creates a new chat history manager on the active stores
 func NewChatHistory(contextSize int) *ChatHistory {
 	return &ChatHistory{
 		contextSize: contextSize,
 	}
 }
 
-// AddMessage adds a message to the conversation history for a specific agent
-func (ch *ChatHistory) AddMessage(agentID int, role, content string) error {
-	// Generate embedding for the message
-	embedding, err := GenerateEmbedding(content)
-	if err != nil {
-		log.Printf("Warning: Could not generate embedding for message: %v", err)
-		// Continue without embedding
+// NewChatHistoryWithStores creates a chat history manager on explicit stores
+func NewChatHistoryWithStores(contextSize int, messages MessageStore, vectors VectorStore, summaries SummaryStore) *ChatHistory {
+	return &ChatHistory{
+		contextSize: contextSize,
+		messages:    messages,
+		vectors:     vectors,
+		summaries:   summaries,
 	}
+}
 
-	// Convert embedding to JSON for storage
-	embeddingJSON, err := json.Marshal(embedding)
-	if err != nil {
-		log.Printf("Warning: Could not marshal embedding: %v", err)
-		// Continue without embedding
+// messageStore returns the message store this history works on. The active store is
+// looked up on every call so package-level histories follow UseStores.
+func (ch *ChatHistory) messageStore() MessageStore {
+	if ch.messages != nil {
+		return ch.messages
 	}
+	return stores.Messages
+}
 
-	// Insert the message with embedding
-	query := `
-		INSERT INTO chat_history (agent_id, role, content, embedding)
-		VALUES ($1, $2, $3, $4)`
-
-	_, err = database.Exec(query, agentID, role, content, embeddingJSON)
-	if err != nil {
-		log.Printf("Error adding message to chat history: %v", err)
-		return err
+// vectorStore returns the vector store this history works on
+func (ch *ChatHistory) vectorStore() VectorStore {
+	if ch.vectors != nil {
+		return ch.vectors
 	}
+	return stores.Vectors
+}
 
-	return nil
+// AddMessage adds a message to the history of the scope's agent and conversation
+func (ch *ChatHistory) AddMessage(scope HistoryScope, role, content string) error {
+	return ch.insertMessage(scope, Message{Role: role, Content: content})
 }
 
-// GetHistory returns the conversation history for a specific agent
-// Limited to the most recent contextSize messages for context building
-func (ch *ChatHistory) GetHistory(agentID int) []Message {
-	query := `
-		SELECT id, role, content 
-		FROM chat_history 
-		WHERE agent_id = $1 
-		ORDER BY created_at DESC
-		LIMIT $2`
-
-	db := database.GetDB()
-	rows, err := db.Query(query, agentID, ch.contextSize)
-	if err != nil {
-		log.Printf("Error getting chat history: %v", err)
-		return []Message{}
+// AddToolMessage records a tool call requested by the model (role "tool_call", content
+// holding the JSON arguments) or a tool result (role "tool") in the history of the scope
+func (ch *ChatHistory) AddToolMessage(scope HistoryScope, role, toolCallID, toolName, content string) error {
+	return ch.insertMessage(scope, Message{Role: role, Content: content, ToolCallID: toolCallID, ToolName: toolName})
+}
+
+// insertMessage stores a message and its embedding. With an embedding pipeline running
+// the embedding is computed in the background and the message is returned right away.
+func (ch *ChatHistory) insertMessage(scope HistoryScope, msg Message) error {
+	if err := ch.messageStore().Add(scope, &msg); err != nil {
+		return err
+	}
+
+	// Blank messages have nothing to embed and never turn up in similarity search
+	if strings.TrimSpace(msg.Content) == "" {
+		return nil
 	}
-	defer rows.Close()
 
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content); err != nil {
-			log.Printf("Error scanning chat history row: %v", err)
-			continue
+	if pipeline := activePipeline.Load(); pipeline != nil && ch.vectors == nil {
+		if !pipeline.Enqueue(msg) {
+			log.Printf("Warning: Embedding queue is full, message %d will be embedded by the backfill", msg.ID)
 		}
-		messages = append(messages, msg)
+		return nil
 	}
 
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating chat history rows: %v", err)
+	// Generate embedding for the message
+	embedding, err := GenerateEmbedding(msg.Content)
+	if err != nil {
+		// Continue without embedding, the message is stored already
+		log.Printf("Warning: Could not generate embedding for message: %v", err)
+		return nil
 	}
 
-	// Reverse the messages to get chronological order (oldest first)
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	if err := ch.vectorStore().SetEmbedding(msg.ID, embedding); err != nil {
+		log.Printf("Warning: Could not store embedding for message %d: %v", msg.ID, err)
 	}
 
-	return messages
+	return nil
 }
 
-// GetFullHistory returns the complete conversation history for a specific agent
-func (ch *ChatHistory) GetFullHistory(agentID int) []Message {
-	query := `
-		SELECT id, role, content 
-		FROM chat_history 
-		WHERE agent_id = $1 
-		ORDER BY created_at ASC`
-
-	db := database.GetDB()
-	rows, err := db.Query(query, agentID)
+// GetHistory returns the conversation history for a scope
+// Limited to the most recent contextSize messages for context building
+func (ch *ChatHistory) GetHistory(scope HistoryScope) []Message {
+	messages, err := ch.messageStore().Recent(scope, ch.contextSize)
 	if err != nil {
-		log.Printf("Error getting full chat history: %v", err)
+		log.Printf("Error getting chat history: %v", err)
 		return []Message{}
 	}
-	defer rows.Close()
-
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content); err != nil {
-			log.Printf("Error scanning chat history row: %v", err)
-			continue
-		}
-		messages = append(messages, msg)
-	}
+	return messages
+}
 
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating chat history rows: %v", err)
+// GetFullHistory returns the complete conversation history for a scope
+func (ch *ChatHistory) GetFullHistory(scope HistoryScope) []Message {
+	messages, err := ch.messageStore().All(scope)
+	if err != nil {
+		log.Printf("Error getting full chat history: %v", err)
+		return []Message{}
 	}
-
 	return messages
 }
 
-// SearchSimilarMessages finds messages similar to the query using embeddings
-func (ch *ChatHistory) SearchSimilarMessages(agentID int, query string, limit int) ([]Message, error) {
-	// Generate embedding for the query
-	queryEmbedding, err := GenerateEmbedding(query)
-	if err != nil {
-		return nil, fmt.Errorf("error generating embedding for query: %v", err)
+// HistoryPage is one page of a scope's history. NextCursor continues after the last
+// message of the page and is 0 when there are no more matching messages.
+type HistoryPage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor int       `json:"next_cursor,omitempty"`
+}
+
+// DefaultHistoryPageSize is the page size GetHistoryPage uses when the filter sets none
+const DefaultHistoryPageSize = 50
+
+// GetHistoryPage returns a page of the scope's history matching the filter. A filter
+// without a positive limit gets pages of DefaultHistoryPageSize messages.
+func (ch *ChatHistory) GetHistoryPage(scope HistoryScope, filter HistoryFilter) (HistoryPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultHistoryPageSize
 	}
 
-	// Convert embedding to JSON for the query
-	queryEmbeddingJSON, err := json.Marshal(queryEmbedding)
+	// Fetch one extra message to learn whether another page follows
+	limit := filter.Limit
+	filter.Limit++
+	messages, err := ch.messageStore().Page(scope, filter)
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling query embedding: %v", err)
+		return HistoryPage{}, fmt.Errorf("error reading chat history: %w", err)
 	}
 
-	// Search for similar messages using cosine similarity
-	sqlQuery := `
-		SELECT id, role, content, embedding, embedding <=> $1 AS similarity
-		FROM chat_history
-		WHERE agent_id = $2 AND embedding IS NOT NULL
-		ORDER BY similarity ASC
-		LIMIT $3`
+	page := HistoryPage{Messages: messages}
+	if page.Messages == nil {
+		page.Messages = []Message{}
+	}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		page.NextCursor = page.Messages[limit-1].ID
+	}
+	return page, nil
+}
 
-	db := database.GetDB()
-	rows, err := db.Query(sqlQuery, queryEmbeddingJSON, agentID, limit)
+// SearchSimilarMessages finds the messages within the scope most relevant to the query,
+// fusing embedding similarity with full-text matches using the default retrieval weights
+func (ch *ChatHistory) SearchSimilarMessages(scope HistoryScope, query string, limit int) ([]Message, error) {
+	results, err := ch.SearchMemories(scope, query, SearchFilter{Limit: limit}, DefaultRetrievalWeights())
 	if err != nil {
-		return nil, fmt.Errorf("error searching similar messages: %v", err)
+		return nil, err
 	}
-	defer rows.Close()
-
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		var similarity float32
-		var embeddingJSON []byte
 
-		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content, &embeddingJSON, &similarity); err != nil {
-			log.Printf("Error scanning search result: %v", err)
-			continue
-		}
+	messages := make([]Message, 0, len(results))
+	for _, result := range results {
+		messages = append(messages, result.Message)
+	}
 
-		// Print the message ID to the console
-		log.Printf("Found similar message with ID: %d, similarity: %f", msg.ID, similarity)
+	return messages, nil
+}
 
-		// Only try to unmarshal if we have embedding data
-		if len(embeddingJSON) > 0 {
-			if err := json.Unmarshal(embeddingJSON, &msg.Embedding); err != nil {
-				log.Printf("Warning: Could not unmarshal embedding: %v", err)
+// SearchMemories returns the messages of the scope matching the filter that are most
+// relevant to the query. Vector and full-text results are fused with reciprocal rank
+// fusion; if the query cannot be embedded only full-text results are used.
+func (ch *ChatHistory) SearchMemories(scope HistoryScope, query string, filter SearchFilter, weights RetrievalWeights) ([]ScoredMessage, error) {
+	limit := filter.Limit
+	filter.Limit = max(limit*retrievalCandidates, 20)
+
+	var vector, lexical []ScoredMessage
+	if weights.Vector > 0 {
+		// Generate embedding for the query
+		queryEmbedding, err := GenerateEmbedding(query)
+		if err != nil {
+			if weights.Lexical == 0 {
+				return nil, fmt.Errorf("error generating embedding for query: %v", err)
 			}
+			log.Printf("Warning: Could not embed search query, using full-text search only: %v", err)
+		} else if vector, err = ch.vectorStore().Search(scope, queryEmbedding, filter); err != nil {
+			return nil, err
 		}
-
-		messages = append(messages, msg)
 	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating search results: %v", err)
+	if weights.Lexical > 0 {
+		var err error
+		if lexical, err = ch.messageStore().SearchText(scope, query, filter); err != nil {
+			return nil, err
+		}
 	}
 
-	return messages, nil
+	return FuseResults(vector, lexical, weights, limit), nil
 }
 
-// ClearHistory clears the conversation history for a specific agent
-func (ch *ChatHistory) ClearHistory(agentID int) {
-	query := `DELETE FROM chat_history WHERE agent_id = $1`
-	_, err := database.Exec(query, agentID)
-	if err != nil {
+// ClearHistory clears the conversation history and its summary for a scope
+func (ch *ChatHistory) ClearHistory(scope HistoryScope) {
+	if err := ch.messageStore().Clear(scope); err != nil {
 		log.Printf("Error clearing chat history: %v", err)
 	}
+	if err := ch.summaryStore().Delete(scope); err != nil {
+		log.Printf("Error clearing chat summary: %v", err)
+	}
 }
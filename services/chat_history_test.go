@@ -0,0 +1,147 @@
+package services
+
+import (
+	"testing"
+)
+
+// useMemoryBackends switches the package to in-memory stores and the offline hashing
+// embedder for the duration of a test
+func useMemoryBackends(t *testing.T) Stores {
+	t.Helper()
+
+	previousStores := stores
+	previousEmbedder := activeEmbedder
+	t.Cleanup(func() {
+		UseStores(previousStores)
+		UseEmbedder(previousEmbedder)
+	})
+
+	memory := NewMemoryStores()
+	UseStores(memory)
+	UseEmbedder(NewHashEmbedder(256))
+	return memory
+}
+
+func TestChatHistoryAddAndGetHistory(t *testing.T) {
+	useMemoryBackends(t)
+	ch := NewChatHistory(3)
+	scope := ConversationScope(1, 1)
+	other := ConversationScope(1, 2)
+
+	contents := []string{"one", "two", "three", "four"}
+	for i, content := range contents {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		if err := ch.AddMessage(scope, role, content); err != nil {
+			t.Fatalf("AddMessage(%q): %v", content, err)
+		}
+	}
+	if err := ch.AddMessage(other, "user", "elsewhere"); err != nil {
+		t.Fatalf("AddMessage in other conversation: %v", err)
+	}
+
+	history := ch.GetHistory(scope)
+	if len(history) != 3 {
+		t.Fatalf("GetHistory returned %d messages, want the last 3: %+v", len(history), history)
+	}
+	for i, want := range []string{"two", "three", "four"} {
+		if history[i].Content != want {
+			t.Errorf("history[%d] = %q, want %q", i, history[i].Content, want)
+		}
+	}
+	if history[0].Role != "assistant" || history[1].Role != "user" {
+		t.Errorf("roles not kept: %+v", history)
+	}
+
+	if full := ch.GetFullHistory(scope); len(full) != len(contents) {
+		t.Errorf("GetFullHistory returned %d messages, want %d", len(full), len(contents))
+	}
+	if agent := ch.GetFullHistory(AgentScope(1)); len(agent) != len(contents)+1 {
+		t.Errorf("agent scope returned %d messages, want %d", len(agent), len(contents)+1)
+	}
+}
+
+func TestChatHistorySearchSimilarMessages(t *testing.T) {
+	memory := useMemoryBackends(t)
+	ch := NewChatHistory(10)
+	scope := ConversationScope(1, 1)
+
+	for _, content := range []string{
+		"the deployment failed with error E1234",
+		"lunch was a sandwich",
+		"we should water the plants",
+	} {
+		if err := ch.AddMessage(scope, "user", content); err != nil {
+			t.Fatalf("AddMessage(%q): %v", content, err)
+		}
+	}
+	if err := ch.AddMessage(ConversationScope(2, 3), "user", "error E1234 for another agent"); err != nil {
+		t.Fatalf("AddMessage for another agent: %v", err)
+	}
+
+	missing, err := memory.Vectors.MissingEmbeddings(EmbeddingBacklog{Limit: 100})
+	if err != nil {
+		t.Fatalf("MissingEmbeddings: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("%d messages were stored without an embedding", len(missing))
+	}
+
+	results, err := ch.SearchSimilarMessages(scope, "deployment error E1234", 2)
+	if err != nil {
+		t.Fatalf("SearchSimilarMessages: %v", err)
+	}
+	if len(results) == 0 || len(results) > 2 {
+		t.Fatalf("SearchSimilarMessages returned %d messages, want 1 or 2", len(results))
+	}
+	if results[0].Content != "the deployment failed with error E1234" {
+		t.Errorf("best match = %q, want the deployment message", results[0].Content)
+	}
+	for _, msg := range results {
+		if msg.Content == "error E1234 for another agent" {
+			t.Errorf("search returned a message of another agent")
+		}
+	}
+}
+
+func TestChatHistoryGetHistoryPage(t *testing.T) {
+	useMemoryBackends(t)
+	ch := NewChatHistory(10)
+	scope := ConversationScope(1, 1)
+
+	for i := 0; i < DefaultHistoryPageSize+5; i++ {
+		if err := ch.AddMessage(scope, "user", "message"); err != nil {
+			t.Fatalf("AddMessage: %v", err)
+		}
+	}
+
+	for _, limit := range []int{0, -1} {
+		page, err := ch.GetHistoryPage(scope, HistoryFilter{Limit: limit})
+		if err != nil {
+			t.Fatalf("GetHistoryPage(limit=%d): %v", limit, err)
+		}
+		if len(page.Messages) != DefaultHistoryPageSize {
+			t.Errorf("GetHistoryPage(limit=%d) returned %d messages, want %d", limit, len(page.Messages), DefaultHistoryPageSize)
+		}
+		if page.NextCursor == 0 {
+			t.Errorf("GetHistoryPage(limit=%d) has no next cursor", limit)
+		}
+	}
+
+	page, err := ch.GetHistoryPage(scope, HistoryFilter{Limit: 2, Ascending: true})
+	if err != nil {
+		t.Fatalf("GetHistoryPage: %v", err)
+	}
+	if len(page.Messages) != 2 || page.NextCursor != page.Messages[1].ID {
+		t.Fatalf("first page = %+v, want 2 messages and a cursor at the second", page)
+	}
+	rest, err := ch.GetHistoryPage(scope, HistoryFilter{Limit: 1000, AfterID: page.NextCursor, Ascending: true})
+	if err != nil {
+		t.Fatalf("GetHistoryPage after cursor: %v", err)
+	}
+	if len(rest.Messages) != DefaultHistoryPageSize+3 || rest.NextCursor != 0 {
+		t.Errorf("last page has %d messages and cursor %d, want %d and none", len(rest.Messages), rest.NextCursor, DefaultHistoryPageSize+3)
+	}
+}
@@ -1,11 +1,18 @@
 package services
 
 import (
+	"bytes"
 	"strings"
 	"text/template"
-	"bytes"
 )
 
+// contextFuncs are the functions available to ContextBuilder templates
+var contextFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
 // ContextBuilder helps build context strings from templates
 type ContextBuilder struct {
 	template string
@@ -36,8 +43,8 @@ func (cb *ContextBuilder) WithVars(vars map[string]interface{}) *ContextBuilder
 
 // Build processes the template and returns the final context string
 func (cb *ContextBuilder) Build() (string, error) {
-	// Parse the template
-	tmpl, err := template.New("context").Parse(cb.template)
+	// Parse the template; a missing variable is an error rather than "<no value>"
+	tmpl, err := template.New("context").Funcs(contextFuncs).Option("missingkey=error").Parse(cb.template)
 	if err != nil {
 		return "", err
 	}
@@ -51,30 +58,3 @@ func (cb *ContextBuilder) Build() (string, error) {
 	// Return the processed string
 	return buf.String(), nil
 }
-
-// BuildContext replaces template variables {{varName}} with values from the state map
-func BuildContext(template string, state map[string]string) string {
-	result := template
-
-	// Replace each variable in the template with its value from state
-	for key, value := range state {
-		placeholder := "{{" + key + "}}"
-		result = strings.ReplaceAll(result, placeholder, value)
-	}
-
-	return result
-}
-
-// Example usage:
-// template := `You are {{role}}, an AI assistant specialized in {{specialty}}.
-// Your task is to {{task}}.
-// Use these key traits in your responses: {{traits}}`
-//
-// state := map[string]string{
-//     "role": "Tech Expert",
-//     "specialty": "programming",
-//     "task": "help users write better code",
-//     "traits": "clear, precise, and educational",
-// }
-//
-// context := BuildContext(template, state) 
\ No newline at end of file
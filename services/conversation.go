@@ -0,0 +1,48 @@
+package services
+
+import (
+	"ai-agent-app/models"
+	"fmt"
+)
+
+// CreateConversation saves a new conversation and fills in its ID and creation time
+func CreateConversation(conversation *models.Conversation) error {
+	return stores.Conversations.Create(conversation)
+}
+
+// GetConversationByID retrieves a conversation by its ID
+func GetConversationByID(id int) (*models.Conversation, error) {
+	return stores.Conversations.GetByID(id)
+}
+
+// ListConversations returns the conversations of an agent, newest first.
+// An empty owner returns the conversations of every owner.
+func ListConversations(agentID int, owner string) ([]models.Conversation, error) {
+	return stores.Conversations.List(agentID, owner)
+}
+
+// GetOrCreateConversation returns the most recent conversation of owner with the agent,
+// creating one with the given title if there is none. An empty owner only matches
+// conversations without an owner.
+func GetOrCreateConversation(agentID int, owner, title string) (*models.Conversation, error) {
+	conversations, err := ListConversations(agentID, owner)
+	if err != nil {
+		return nil, err
+	}
+	for _, conversation := range conversations {
+		if conversation.Owner == owner {
+			return &conversation, nil
+		}
+	}
+
+	conversation := models.Conversation{AgentID: agentID, Owner: owner, Title: title}
+	if err := CreateConversation(&conversation); err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	return &conversation, nil
+}
+
+// DeleteConversation removes a conversation together with its messages
+func DeleteConversation(id int) error {
+	return stores.Conversations.Delete(id)
+}
@@ -7,35 +7,229 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 )
 
-// EmbeddingDimension is the dimension of the embedding vectors
+// EmbeddingDimension is the default dimension of the embedding vectors
 const EmbeddingDimension = 1536 // OpenAI's text-embedding-ada-002 model uses 1536 dimensions
 
+// OpenAIEmbeddingsURL is the endpoint for the OpenAI embeddings API
+const OpenAIEmbeddingsURL = "https://api.openai.com/v1/embeddings"
+
+// DefaultOpenAIEmbeddingModel is used when EMBEDDING_MODEL is not set
+const DefaultOpenAIEmbeddingModel = "text-embedding-ada-002"
+
+// LocalEmbeddingsURL is the default embeddings endpoint of an OpenAI-compatible local server (Ollama)
+const LocalEmbeddingsURL = "http://localhost:11434/v1/embeddings"
+
+// DefaultLocalEmbeddingModel and DefaultLocalEmbeddingDimension describe the default local model
+const (
+	DefaultLocalEmbeddingModel     = "nomic-embed-text"
+	DefaultLocalEmbeddingDimension = 768
+)
+
 // EmbeddingRequest represents a request to the OpenAI embeddings API
 type EmbeddingRequest struct {
-	Model string   `json:"model"`
-	Input []string `json:"input"`
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"` // Only supported by text-embedding-3 models
 }
 
 // EmbeddingResponse represents a response from the OpenAI embeddings API
 type EmbeddingResponse struct {
 	Data []struct {
+		Index     int       `json:"index"`
 		Embedding []float32 `json:"embedding"`
 	} `json:"data"`
 }
 
-// GenerateEmbedding generates an embedding for the given text using OpenAI's API
+// Embedder turns text into embedding vectors
+type Embedder interface {
+	// Embed returns one embedding per input text, in the same order
+	Embed(texts []string) ([][]float32, error)
+	// Model identifies the embedding model, so vectors from different models are never mixed
+	Model() string
+	// Dimension is the length of every vector the embedder returns
+	Dimension() int
+}
+
+var (
+	embedderMu     sync.RWMutex
+	activeEmbedder Embedder
+)
+
+// UseEmbedder sets the embedder used by GenerateEmbedding
+func UseEmbedder(e Embedder) {
+	embedderMu.Lock()
+	defer embedderMu.Unlock()
+	activeEmbedder = e
+}
+
+// CurrentEmbedder returns the embedder in use, configuring one from the environment
+// on first use if UseEmbedder was never called
+func CurrentEmbedder() Embedder {
+	embedderMu.RLock()
+	e := activeEmbedder
+	embedderMu.RUnlock()
+	if e != nil {
+		return e
+	}
+
+	embedderMu.Lock()
+	defer embedderMu.Unlock()
+	if activeEmbedder == nil {
+		configured, err := NewEmbedderFromEnv()
+		if err != nil {
+			// Fall back to the historical default rather than failing every request. The
+			// default model with its native size cannot be rejected.
+			configured, _ = NewOpenAIEmbedder(os.Getenv("OPENAI_API_KEY"), "", 0)
+		}
+		activeEmbedder = configured
+	}
+	return activeEmbedder
+}
+
+// NewEmbedderFromEnv builds the embedder selected by EMBEDDING_PROVIDER ("openai", "local"
+// or "hash") with the model and dimension from EMBEDDING_MODEL and EMBEDDING_DIMENSION
+func NewEmbedderFromEnv() (Embedder, error) {
+	dimension := 0
+	if value := os.Getenv("EMBEDDING_DIMENSION"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid EMBEDDING_DIMENSION %q", value)
+		}
+		dimension = n
+	}
+	model := os.Getenv("EMBEDDING_MODEL")
+
+	switch provider := os.Getenv("EMBEDDING_PROVIDER"); provider {
+	case "", "openai":
+		return NewOpenAIEmbedder(os.Getenv("OPENAI_API_KEY"), model, dimension)
+	case "local":
+		return NewLocalEmbedder(os.Getenv("EMBEDDING_URL"), os.Getenv("EMBEDDING_API_KEY"), model, dimension), nil
+	case "hash":
+		return NewHashEmbedder(dimension), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", provider)
+	}
+}
+
+// GenerateEmbedding generates an embedding for the given text with the current embedder
 func GenerateEmbedding(text string) ([]float32, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY environment variable is not set")
+	embeddings, err := GenerateEmbeddings([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// GenerateEmbeddings embeds several texts in a single request
+func GenerateEmbeddings(texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	return CurrentEmbedder().Embed(texts)
+}
+
+// openAICompatibleEmbedder calls an OpenAI-style /v1/embeddings endpoint
+type openAICompatibleEmbedder struct {
+	url            string
+	apiKey         string
+	model          string
+	dimension      int
+	sendDimensions bool
+	requireKey     bool
+	keyVariable    string
+}
+
+// openAIEmbeddingModels lists the native size of known OpenAI embedding models and
+// whether they can return shorter vectors through the dimensions parameter
+var openAIEmbeddingModels = map[string]struct {
+	dimension int
+	shorten   bool
+}{
+	"text-embedding-ada-002": {1536, false},
+	"text-embedding-3-small": {1536, true},
+	"text-embedding-3-large": {3072, true},
+}
+
+// NewOpenAIEmbedder creates an embedder for the OpenAI embeddings API. A zero dimension
+// uses the model's native size; a custom one is requested from text-embedding-3 models.
+// A dimension a known model cannot produce is rejected.
+func NewOpenAIEmbedder(apiKey, model string, dimension int) (Embedder, error) {
+	if model == "" {
+		model = DefaultOpenAIEmbeddingModel
+	}
+	sendDimensions := dimension > 0
+	if known, ok := openAIEmbeddingModels[model]; ok {
+		switch {
+		case dimension <= 0 || dimension == known.dimension:
+			dimension = known.dimension
+			sendDimensions = false
+		case !known.shorten:
+			return nil, fmt.Errorf("embedding model %s only returns %d dimensions, not %d", model, known.dimension, dimension)
+		case dimension > known.dimension:
+			return nil, fmt.Errorf("embedding model %s returns at most %d dimensions, not %d", model, known.dimension, dimension)
+		}
+	}
+	if dimension <= 0 {
+		dimension = EmbeddingDimension
+	}
+	return &openAICompatibleEmbedder{
+		url:            OpenAIEmbeddingsURL,
+		apiKey:         apiKey,
+		model:          model,
+		dimension:      dimension,
+		sendDimensions: sendDimensions,
+		requireKey:     true,
+		keyVariable:    "OPENAI_API_KEY",
+	}, nil
+}
+
+// NewLocalEmbedder creates an embedder for a local OpenAI-compatible server such as
+// Ollama or llama.cpp. The dimension must match the model's output size.
+func NewLocalEmbedder(url, apiKey, model string, dimension int) Embedder {
+	if url == "" {
+		url = LocalEmbeddingsURL
+	}
+	if model == "" {
+		model = DefaultLocalEmbeddingModel
+	}
+	if dimension <= 0 {
+		dimension = DefaultLocalEmbeddingDimension
+	}
+	return &openAICompatibleEmbedder{
+		url:       url,
+		apiKey:    apiKey,
+		model:     model,
+		dimension: dimension,
+	}
+}
+
+// Model returns the embedding model name
+func (e *openAICompatibleEmbedder) Model() string {
+	return e.model
+}
+
+// Dimension returns the length of the returned vectors
+func (e *openAICompatibleEmbedder) Dimension() int {
+	return e.dimension
+}
+
+// Embed sends the texts to the embeddings endpoint in one request
+func (e *openAICompatibleEmbedder) Embed(texts []string) ([][]float32, error) {
+	if e.requireKey && e.apiKey == "" {
+		return nil, fmt.Errorf("%s environment variable is not set", e.keyVariable)
 	}
 
 	// Create the request body
 	requestBody := EmbeddingRequest{
-		Model: "text-embedding-ada-002",
-		Input: []string{text},
+		Model: e.model,
+		Input: texts,
+	}
+	if e.sendDimensions {
+		requestBody.Dimensions = e.dimension
 	}
 
 	// Convert request to JSON
@@ -45,18 +239,19 @@ func GenerateEmbedding(text string) ([]float32, error) {
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", e.url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending request: %v", err)
 	}
@@ -79,39 +274,23 @@ func GenerateEmbedding(text string) ([]float32, error) {
 		return nil, fmt.Errorf("error parsing response: %v", err)
 	}
 
-	// Check if we have any embeddings
-	if len(embeddingResponse.Data) == 0 {
-		return nil, fmt.Errorf("no embeddings returned")
-	}
-
-	// Return the embedding
-	return embeddingResponse.Data[0].Embedding, nil
-}
-
-// CosineSimilarity calculates the cosine similarity between two embedding vectors
-func CosineSimilarity(a, b []float32) float32 {
-	if len(a) != len(b) {
-		return 0
+	// Check that we got one embedding per input
+	if len(embeddingResponse.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddingResponse.Data))
 	}
 
-	var dotProduct float32
-	var normA float32
-	var normB float32
-
-	for i := 0; i < len(a); i++ {
-		dotProduct += a[i] * b[i]
-		normA += a[i] * a[i]
-		normB += b[i] * b[i]
+	// Return the embeddings in input order, rejecting vectors the database column cannot hold
+	embeddings := make([][]float32, len(texts))
+	for position, data := range embeddingResponse.Data {
+		index := data.Index
+		if index < 0 || index >= len(texts) {
+			index = position
+		}
+		if len(data.Embedding) != e.dimension {
+			return nil, fmt.Errorf("model %s returned %d dimensions, expected %d", e.model, len(data.Embedding), e.dimension)
+		}
+		embeddings[index] = data.Embedding
 	}
 
-	if normA == 0 || normB == 0 {
-		return 0
-	}
-
-	return dotProduct / (float32(sqrt(float64(normA))) * float32(sqrt(float64(normB))))
+	return embeddings, nil
 }
-
-// sqrt is a helper function to calculate square root
-func sqrt(x float64) float64 {
-	return float64(x * x)
-} 
\ No newline at end of file
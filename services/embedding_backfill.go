@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultBackfillBatchSize is the number of messages embedded per request during a backfill
+const DefaultBackfillBatchSize = 32
+
+// backfillGracePeriod leaves recently added messages to the embedding pipeline
+const backfillGracePeriod = time.Minute
+
+// BackfillEmbeddings embeds stored messages that have no embedding, for example because
+// the embedding service was down when they were added. Messages added in the last minute
+// are left to the embedding pipeline, and messages that failed maxAttempts times are given
+// up on. Messages are embedded in batches of batchSize; the pass stops at the first failed
+// batch so an unavailable embedder is not hammered, and the remaining messages are left
+// for the next pass. It returns the number of messages embedded.
+func BackfillEmbeddings(batchSize, maxAttempts int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBackfillBatchSize
+	}
+
+	backlog := EmbeddingBacklog{
+		Before:      time.Now().Add(-backfillGracePeriod),
+		MaxAttempts: maxAttempts,
+		Limit:       batchSize,
+	}
+	embedded := 0
+	for {
+		messages, err := stores.Vectors.MissingEmbeddings(backlog)
+		if err != nil {
+			return embedded, fmt.Errorf("error finding messages without embeddings: %w", err)
+		}
+		if len(messages) == 0 {
+			return embedded, nil
+		}
+		backlog.AfterID = messages[len(messages)-1].ID
+
+		texts := make([]string, len(messages))
+		ids := make([]int, len(messages))
+		for i, msg := range messages {
+			texts[i] = msg.Content
+			ids[i] = msg.ID
+		}
+		embeddings, err := GenerateEmbeddings(texts)
+		if err != nil {
+			if err := stores.Vectors.RecordEmbeddingFailure(ids); err != nil {
+				log.Printf("Warning: Could not record embedding failure: %v", err)
+			}
+			return embedded, fmt.Errorf("error generating embeddings: %w", err)
+		}
+
+		for i, msg := range messages {
+			if err := stores.Vectors.SetEmbedding(msg.ID, embeddings[i]); err != nil {
+				return embedded, fmt.Errorf("error storing embedding for message %d: %w", msg.ID, err)
+			}
+			embedded++
+		}
+	}
+}
+
+// StartEmbeddingBackfill runs BackfillEmbeddings now and then every interval until stop
+// is closed. A nil stop channel runs for the lifetime of the process.
+func StartEmbeddingBackfill(interval time.Duration, batchSize, maxAttempts int, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// An embedder that stays down fails every pass the same way, so only changes are logged
+	lastError := ""
+	for {
+		embedded, err := BackfillEmbeddings(batchSize, maxAttempts)
+		if embedded > 0 {
+			log.Printf("Backfilled embeddings for %d messages", embedded)
+		}
+		switch {
+		case err != nil && err.Error() != lastError:
+			log.Printf("Warning: Embedding backfill stopped early: %v", err)
+			lastError = err.Error()
+		case err == nil:
+			lastError = ""
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// DefaultEmbeddingCacheSize is the number of embeddings kept in memory by default
+const DefaultEmbeddingCacheSize = 10000
+
+// CachingEmbedder wraps an embedder so identical text is embedded once. Embeddings are
+// kept in an in-memory LRU and, when a store is given, in a table shared by every
+// instance and kept across restarts. Entries are keyed by model, dimension and a SHA-256
+// hash of the text, so switching models never returns stale vectors.
+type CachingEmbedder struct {
+	embedder Embedder
+	store    EmbeddingCacheStore // Optional second level behind the LRU
+
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first
+}
+
+// embeddingCacheEntry is an LRU element
+type embeddingCacheEntry struct {
+	hash      string
+	embedding []float32
+}
+
+// NewCachingEmbedder wraps embedder with an LRU of size entries and an optional store
+func NewCachingEmbedder(embedder Embedder, size int, store EmbeddingCacheStore) *CachingEmbedder {
+	return &CachingEmbedder{
+		embedder: embedder,
+		store:    store,
+		size:     size,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Model returns the model of the wrapped embedder
+func (c *CachingEmbedder) Model() string {
+	return c.embedder.Model()
+}
+
+// Dimension returns the dimension of the wrapped embedder
+func (c *CachingEmbedder) Dimension() int {
+	return c.embedder.Dimension()
+}
+
+// Embed returns cached embeddings where available and embeds the remaining texts in one
+// request to the wrapped embedder. Repeated texts within the batch are embedded once.
+func (c *CachingEmbedder) Embed(texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	hashes := make([]string, len(texts))
+	missing := map[string][]int{} // Hash to the positions waiting for it
+	for i, text := range texts {
+		hashes[i] = contentHash(text)
+		if embedding, ok := c.get(hashes[i]); ok {
+			embeddings[i] = embedding
+		} else {
+			missing[hashes[i]] = append(missing[hashes[i]], i)
+		}
+	}
+	if len(missing) == 0 {
+		return embeddings, nil
+	}
+
+	fill := func(hash string, embedding []float32) {
+		for _, i := range missing[hash] {
+			embeddings[i] = embedding
+		}
+		delete(missing, hash)
+		c.put(hash, embedding)
+	}
+
+	// Look the misses up in the shared table before paying for an embedding request
+	if c.store != nil {
+		lookup := make([]string, 0, len(missing))
+		for hash := range missing {
+			lookup = append(lookup, hash)
+		}
+		stored, err := c.store.Get(c.cacheKey(), lookup)
+		if err != nil {
+			log.Printf("Warning: Could not read embedding cache: %v", err)
+		}
+		for hash, embedding := range stored {
+			fill(hash, embedding)
+		}
+		if len(missing) == 0 {
+			return embeddings, nil
+		}
+	}
+
+	// Embed each remaining text once
+	var uncachedHashes []string
+	var uncachedTexts []string
+	for hash, positions := range missing {
+		uncachedHashes = append(uncachedHashes, hash)
+		uncachedTexts = append(uncachedTexts, texts[positions[0]])
+	}
+	generated, err := c.embedder.Embed(uncachedTexts)
+	if err != nil {
+		return nil, err
+	}
+	if len(generated) != len(uncachedTexts) {
+		return nil, fmt.Errorf("embedder returned %d embeddings for %d texts", len(generated), len(uncachedTexts))
+	}
+
+	fresh := make(map[string][]float32, len(generated))
+	for i, hash := range uncachedHashes {
+		fresh[hash] = generated[i]
+		fill(hash, generated[i])
+	}
+	if c.store != nil {
+		if err := c.store.Put(c.cacheKey(), fresh); err != nil {
+			log.Printf("Warning: Could not write embedding cache: %v", err)
+		}
+	}
+
+	return embeddings, nil
+}
+
+// cacheKey identifies the model and dimension in the shared table
+func (c *CachingEmbedder) cacheKey() string {
+	return fmt.Sprintf("%s/%d", c.embedder.Model(), c.embedder.Dimension())
+}
+
+// get returns a cached embedding and marks it as recently used
+func (c *CachingEmbedder) get(hash string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*embeddingCacheEntry).embedding, true
+}
+
+// put caches an embedding, evicting the least recently used one when the cache is full
+func (c *CachingEmbedder) put(hash string, embedding []float32) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[hash]; ok {
+		element.Value.(*embeddingCacheEntry).embedding = embedding
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[hash] = c.order.PushFront(&embeddingCacheEntry{hash: hash, embedding: embedding})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*embeddingCacheEntry).hash)
+	}
+}
+
+// contentHash returns the hex SHA-256 hash of a text
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
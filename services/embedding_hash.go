@@ -0,0 +1,89 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+// hashEmbedder produces deterministic embeddings by feature hashing: every word and
+// word pair is hashed into a bucket of the vector. Texts sharing vocabulary end up
+// close to each other, which is enough for offline tests and air-gapped installs.
+type hashEmbedder struct {
+	dimension int
+}
+
+// NewHashEmbedder creates a deterministic, dependency-free embedder
+func NewHashEmbedder(dimension int) Embedder {
+	if dimension <= 0 {
+		dimension = EmbeddingDimension
+	}
+	return &hashEmbedder{dimension: dimension}
+}
+
+// Model returns the embedding model name
+func (e *hashEmbedder) Model() string {
+	return "hash"
+}
+
+// Dimension returns the length of the returned vectors
+func (e *hashEmbedder) Dimension() int {
+	return e.dimension
+}
+
+// Embed hashes each text into a normalized vector. A text without any visible character
+// has no features to hash and is rejected, since a zero vector has no cosine similarity.
+func (e *hashEmbedder) Embed(texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embedding := e.embed(text)
+		if L2Norm(embedding) == 0 {
+			return nil, fmt.Errorf("text %d has nothing to embed", i)
+		}
+		embeddings[i] = embedding
+	}
+	return embeddings, nil
+}
+
+// embed builds the vector for a single text
+func (e *hashEmbedder) embed(text string) []float32 {
+	vector := make([]float32, e.dimension)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, word := range words {
+		e.add(vector, word, 1)
+		if i > 0 {
+			e.add(vector, words[i-1]+" "+word, 0.5)
+		}
+	}
+
+	// Texts made only of emoji or punctuation are hashed symbol by symbol instead
+	if len(words) == 0 {
+		for _, r := range text {
+			if !unicode.IsSpace(r) {
+				e.add(vector, string(r), 1)
+			}
+		}
+	}
+
+	// Normalize to unit length so cosine and dot product agree
+	NormalizeInPlace(vector)
+	return vector
+}
+
+// add hashes a feature into the vector. One bit of the hash picks the sign so
+// colliding features tend to cancel out instead of piling up.
+func (e *hashEmbedder) add(vector []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+
+	bucket := int(sum % uint64(e.dimension))
+	if sum&(1<<63) != 0 {
+		weight = -weight
+	}
+	vector[bucket] += weight
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHashEmbedderEmbed(t *testing.T) {
+	embedder := NewHashEmbedder(64)
+
+	for _, text := range []string{"deploy the release", "🚀🔥", "?!...", "a"} {
+		t.Run(text, func(t *testing.T) {
+			embeddings, err := embedder.Embed([]string{text})
+			if err != nil {
+				t.Fatalf("Embed(%q): %v", text, err)
+			}
+			if len(embeddings[0]) != 64 {
+				t.Fatalf("Embed(%q) has dimension %d, want 64", text, len(embeddings[0]))
+			}
+			if norm := L2Norm(embeddings[0]); math.Abs(float64(norm)-1) > vectorTolerance {
+				t.Errorf("Embed(%q) has norm %v, want a unit vector", text, norm)
+			}
+
+			again, err := embedder.Embed([]string{text})
+			if err != nil {
+				t.Fatalf("Embed(%q) again: %v", text, err)
+			}
+			if !reflect.DeepEqual(embeddings, again) {
+				t.Errorf("Embed(%q) is not deterministic", text)
+			}
+		})
+	}
+}
+
+func TestHashEmbedderRejectsBlankText(t *testing.T) {
+	embedder := NewHashEmbedder(64)
+
+	for _, text := range []string{"", "   ", "\n\t"} {
+		if embeddings, err := embedder.Embed([]string{"hello", text}); err == nil {
+			t.Errorf("Embed(%q) = %v, want an error", text, embeddings[1])
+		}
+	}
+}
+
+func TestChatHistorySkipsEmbeddingBlankMessages(t *testing.T) {
+	memory := useMemoryBackends(t)
+	ch := NewChatHistory(10)
+	scope := ConversationScope(1, 1)
+
+	for _, content := range []string{"", "  ", "🙂"} {
+		if err := ch.AddMessage(scope, "assistant", content); err != nil {
+			t.Fatalf("AddMessage(%q): %v", content, err)
+		}
+	}
+
+	if history := ch.GetFullHistory(scope); len(history) != 3 {
+		t.Fatalf("GetFullHistory returned %d messages, want 3", len(history))
+	}
+	missing, err := memory.Vectors.MissingEmbeddings(EmbeddingBacklog{Limit: 100})
+	if err != nil {
+		t.Fatalf("MissingEmbeddings: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("MissingEmbeddings returned %d blank messages, want none", len(missing))
+	}
+}
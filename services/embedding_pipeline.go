@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EmbeddingPipelineConfig sizes the background embedding workers
+type EmbeddingPipelineConfig struct {
+	Workers        int           // Concurrent embedding requests
+	BatchSize      int           // Messages embedded per request
+	BatchWait      time.Duration // How long a worker waits for a batch to fill up
+	QueueSize      int           // Messages waiting for a worker
+	EnqueueTimeout time.Duration // How long a full queue holds up a new message
+	Retries        int           // Extra attempts for a failed batch before it is left to the backfill
+	RetryDelay     time.Duration // Delay before the first retry, doubled for each further one
+	// MaxAttempts is the number of failed batches after which a message is given up on
+	MaxAttempts int
+}
+
+// DefaultEmbeddingPipelineConfig returns the pipeline settings used unless overridden
+func DefaultEmbeddingPipelineConfig() EmbeddingPipelineConfig {
+	return EmbeddingPipelineConfig{
+		Workers:        2,
+		BatchSize:      DefaultBackfillBatchSize,
+		BatchWait:      200 * time.Millisecond,
+		QueueSize:      1000,
+		EnqueueTimeout: 2 * time.Second,
+		Retries:        2,
+		RetryDelay:     time.Second,
+		MaxAttempts:    5,
+	}
+}
+
+// EmbeddingPipelineConfigFromEnv reads EMBEDDING_WORKERS, EMBEDDING_BATCH_SIZE,
+// EMBEDDING_QUEUE_SIZE and EMBEDDING_MAX_ATTEMPTS on top of the defaults
+func EmbeddingPipelineConfigFromEnv() (EmbeddingPipelineConfig, error) {
+	config := DefaultEmbeddingPipelineConfig()
+	settings := []struct {
+		name   string
+		target *int
+	}{
+		{"EMBEDDING_WORKERS", &config.Workers},
+		{"EMBEDDING_BATCH_SIZE", &config.BatchSize},
+		{"EMBEDDING_QUEUE_SIZE", &config.QueueSize},
+		{"EMBEDDING_MAX_ATTEMPTS", &config.MaxAttempts},
+	}
+	for _, setting := range settings {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return config, fmt.Errorf("invalid %s %q: must be a positive integer", setting.name, value)
+		}
+		*setting.target = parsed
+	}
+	return config, nil
+}
+
+// EmbeddingPipeline embeds stored messages in the background. Messages are saved before
+// they are queued, and those without an embedding are found again by the backfill, so the
+// database is the durable queue: a full queue, a failing embedder or a shutdown only
+// delays embeddings.
+type EmbeddingPipeline struct {
+	config EmbeddingPipelineConfig
+	queue  chan Message
+
+	mu     sync.RWMutex // Held for writing while closing the queue
+	closed bool
+	wg     sync.WaitGroup
+}
+
+var activePipeline atomic.Pointer[EmbeddingPipeline]
+
+// NewEmbeddingPipeline starts the workers of a pipeline
+func NewEmbeddingPipeline(config EmbeddingPipelineConfig) *EmbeddingPipeline {
+	p := &EmbeddingPipeline{
+		config: config,
+		queue:  make(chan Message, config.QueueSize),
+	}
+	for i := 0; i < config.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// UseEmbeddingPipeline makes chat histories hand new messages to the pipeline instead
+// of embedding them before returning. A nil pipeline restores synchronous embedding.
+func UseEmbeddingPipeline(p *EmbeddingPipeline) {
+	activePipeline.Store(p)
+}
+
+// StopEmbeddingPipeline stops the active pipeline from taking new messages and waits
+// until the queued ones are embedded or ctx is done. Messages still queued at that point
+// keep no embedding until the next backfill.
+func StopEmbeddingPipeline(ctx context.Context) error {
+	p := activePipeline.Swap(nil)
+	if p == nil {
+		return nil
+	}
+	return p.Drain(ctx)
+}
+
+// Enqueue queues a stored message for embedding. When the queue is full the caller waits
+// up to EnqueueTimeout for room, which slows producers down while the embedder catches up.
+// It reports whether the message was queued.
+func (p *EmbeddingPipeline) Enqueue(msg Message) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+
+	select {
+	case p.queue <- msg:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(p.config.EnqueueTimeout)
+	defer timer.Stop()
+	select {
+	case p.queue <- msg:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Drain stops accepting messages and waits until the workers have embedded the queued
+// ones, or until ctx is done
+func (p *EmbeddingPipeline) Drain(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("embedding queue not drained: %w", ctx.Err())
+	}
+}
+
+// work embeds batches from the queue until it is closed and empty
+func (p *EmbeddingPipeline) work() {
+	defer p.wg.Done()
+	for {
+		batch, ok := p.nextBatch()
+		if len(batch) > 0 {
+			p.embed(batch)
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// nextBatch waits for a message, then collects more until the batch is full or BatchWait
+// has passed. It returns false once the queue is closed and empty.
+func (p *EmbeddingPipeline) nextBatch() ([]Message, bool) {
+	msg, ok := <-p.queue
+	if !ok {
+		return nil, false
+	}
+	batch := []Message{msg}
+
+	timer := time.NewTimer(p.config.BatchWait)
+	defer timer.Stop()
+	for len(batch) < p.config.BatchSize {
+		select {
+		case msg, ok := <-p.queue:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// embed embeds and stores a batch, retrying with backoff. A batch that keeps failing has
+// the attempt recorded and is left for the backfill.
+func (p *EmbeddingPipeline) embed(batch []Message) {
+	texts := make([]string, len(batch))
+	ids := make([]int, len(batch))
+	for i, msg := range batch {
+		texts[i] = msg.Content
+		ids[i] = msg.ID
+	}
+
+	delay := p.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		embeddings, err := GenerateEmbeddings(texts)
+		if err == nil {
+			for i, msg := range batch {
+				if err := stores.Vectors.SetEmbedding(msg.ID, embeddings[i]); err != nil {
+					log.Printf("Warning: Could not store embedding for message %d: %v", msg.ID, err)
+				}
+			}
+			return
+		}
+
+		if attempt >= p.config.Retries {
+			log.Printf("Warning: Could not embed %d messages, leaving them to the backfill: %v", len(batch), err)
+			if err := stores.Vectors.RecordEmbeddingFailure(ids); err != nil {
+				log.Printf("Warning: Could not record embedding failure: %v", err)
+			}
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import "testing"
+
+func TestNewOpenAIEmbedderDimension(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     string
+		dimension int
+		want      int
+		wantErr   bool
+	}{
+		{"default model native size", "", 0, 1536, false},
+		{"ada explicit native size", "text-embedding-ada-002", 1536, 1536, false},
+		{"ada custom size", "", 768, 0, true},
+		{"3-small shortened", "text-embedding-3-small", 512, 512, false},
+		{"3-small too large", "text-embedding-3-small", 3072, 0, true},
+		{"3-large native size", "text-embedding-3-large", 0, 3072, false},
+		{"unknown model custom size", "my-embedder", 1024, 1024, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embedder, err := NewOpenAIEmbedder("key", tt.model, tt.dimension)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewOpenAIEmbedder(%q, %d) succeeded, want an error", tt.model, tt.dimension)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewOpenAIEmbedder(%q, %d): %v", tt.model, tt.dimension, err)
+			}
+			if embedder.Dimension() != tt.want {
+				t.Errorf("Dimension() = %d, want %d", embedder.Dimension(), tt.want)
+			}
+		})
+	}
+}
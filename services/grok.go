@@ -1,38 +1,22 @@
 package services
 
-import (
-	"bytes"
-	"encoding/json"
-	"net/http"
-)
+// GrokAPIURL is the endpoint for the Grok API, which follows the OpenAI chat completions format
+const GrokAPIURL = "https://api.x.ai/v1/chat/completions"
 
-// GrokAPIURL is the endpoint for the Grok API
-const GrokAPIURL = "https://api.grok.com/v1/chat"
+// DefaultGrokModel is used when GROK_MODEL is not set
+const DefaultGrokModel = "grok-2-latest"
 
-// GrokResponse represents the response structure from the Grok API
-type GrokResponse struct {
-	Response string `json:"response"`
-}
-
-// SendMessageToGrok sends a message to the Grok API and returns the response
-func SendMessageToGrok(message string) (string, error) {
-	requestBody, err := json.Marshal(map[string]string{
-		"message": message,
-	})
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := http.Post(GrokAPIURL, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", err
+// NewGrokProvider creates a provider for the xAI Grok chat API
+func NewGrokProvider(apiKey, model string) ChatProvider {
+	if model == "" {
+		model = DefaultGrokModel
 	}
-	defer resp.Body.Close()
-
-	var grokResponse GrokResponse
-	if err := json.NewDecoder(resp.Body).Decode(&grokResponse); err != nil {
-		return "", err
+	return &openAICompatibleProvider{
+		name:        "grok",
+		url:         GrokAPIURL,
+		apiKey:      apiKey,
+		model:       model,
+		requireKey:  true,
+		keyVariable: "GROK_API_KEY",
 	}
-
-	return grokResponse.Response, nil
 }
@@ -0,0 +1,24 @@
+package services
+
+// LocalLLMURL is the default endpoint of an OpenAI-compatible local server (Ollama)
+const LocalLLMURL = "http://localhost:11434/v1/chat/completions"
+
+// DefaultLocalModel is used when LOCAL_LLM_MODEL is not set
+const DefaultLocalModel = "llama3"
+
+// NewLocalProvider creates a provider for a local OpenAI-compatible server such as
+// Ollama or llama.cpp. The API key is optional since most local servers ignore it.
+func NewLocalProvider(url, apiKey, model string) ChatProvider {
+	if url == "" {
+		url = LocalLLMURL
+	}
+	if model == "" {
+		model = DefaultLocalModel
+	}
+	return &openAICompatibleProvider{
+		name:   "local",
+		url:    url,
+		apiKey: apiKey,
+		model:  model,
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,123 +10,328 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"ai-agent-app/services/tools"
 )
 
 // OpenAIAPIURL is the endpoint for the OpenAI API
 const OpenAIAPIURL = "https://api.openai.com/v1/chat/completions"
 
-// OpenAIRequest represents the structure of a request to the OpenAI API
+// DefaultOpenAIModel is used when OPENAI_MODEL is not set
+const DefaultOpenAIModel = "gpt-3.5-turbo"
+
+// OpenAIRequest represents the structure of a request to an OpenAI-compatible chat API
 type OpenAIRequest struct {
-	Model   string `json:"model"`
-	Context string `json:"context"`
+	Model            string          `json:"model"`
+	Messages         []OpenAIMessage `json:"messages"`
+	Tools            []OpenAITool    `json:"tools,omitempty"`
+	Temperature      float64         `json:"temperature"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	MaxTokens        *int            `json:"max_tokens,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	Stream           bool            `json:"stream,omitempty"`
+}
+
+// OpenAIMessage is a chat message in the OpenAI wire format
+type OpenAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// OpenAITool declares a callable function to the model
+type OpenAITool struct {
+	Type     string           `json:"type"`
+	Function tools.Definition `json:"function"`
 }
 
-// OpenAIResponse represents the structure of a response from the OpenAI API
+// OpenAIToolCall is a function call requested by the model. In streamed replies the
+// fields arrive in fragments that are stitched together by Index.
+type OpenAIToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// maxStreamToolCalls bounds the tool call index accepted from a stream, so a bad index
+// cannot make the reader allocate an arbitrary number of calls
+const maxStreamToolCalls = 128
+
+// OpenAIResponse represents the structure of a response from an OpenAI-compatible chat API
 type OpenAIResponse struct {
 	Choices []struct {
 		Message struct {
-			Content string `json:"content"`
+			Content   string           `json:"content"`
+			ToolCalls []OpenAIToolCall `json:"tool_calls"`
 		} `json:"message"`
 	} `json:"choices"`
 }
 
+// OpenAIStreamChunk represents one server-sent event of a streamed chat completion
+type OpenAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string           `json:"content"`
+			ToolCalls []OpenAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
 // Add this at the package level
 var (
+	httpTransport = &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
 	httpClient = &http.Client{
-		Timeout: time.Second * 30,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 100,
-			IdleConnTimeout:     90 * time.Second,
-		},
+		Timeout:   time.Second * 30,
+		Transport: httpTransport,
+	}
+
+	// streamClient has no overall timeout since a streamed answer may take minutes;
+	// a streamWatchdog abandons requests whose upstream stalls instead
+	streamClient = &http.Client{
+		Transport: httpTransport,
 	}
 )
 
-// SendMessageToOpenAI sends a message to the OpenAI API and returns the response
-func SendMessageToOpenAI(apiKey, message, template string, state map[string]string) (string, error) {
-	// Start timing
-	startTime := time.Now()
-	log.Printf("Starting OpenAI API request...")
+// openAICompatibleProvider talks to any backend implementing the OpenAI chat completions API.
+// OpenAI itself, Grok and local servers such as Ollama or llama.cpp all share it.
+type openAICompatibleProvider struct {
+	name        string
+	url         string
+	apiKey      string
+	model       string
+	requireKey  bool
+	keyVariable string
+}
 
-	// Process the template with the state
-	context := template
-	for key, value := range state {
-		context = strings.Replace(context, "{{"+key+"}}", value, -1)
+// NewOpenAIProvider creates a provider for the OpenAI chat completions API
+func NewOpenAIProvider(apiKey, model string) ChatProvider {
+	if model == "" {
+		model = DefaultOpenAIModel
+	}
+	return &openAICompatibleProvider{
+		name:        "openai",
+		url:         OpenAIAPIURL,
+		apiKey:      apiKey,
+		model:       model,
+		requireKey:  true,
+		keyVariable: "OPENAI_API_KEY",
 	}
+}
 
-	// Combine the context and message
-	prompt := context + message
+// Name returns the provider identifier
+func (p *openAICompatibleProvider) Name() string {
+	return p.name
+}
 
-	// Create the request payload
-	requestBody := map[string]interface{}{
-		"model": "gpt-3.5-turbo",
-		"messages": []map[string]string{
-			{
-				"role":    "system",
-				"content": prompt,
-			},
-		},
-		"temperature": 0.7,
-	}
+// Model returns the model requests are sent to
+func (p *openAICompatibleProvider) Model() string {
+	return p.model
+}
 
-	// Convert the request payload to JSON
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %w", err)
-	}
+// Complete sends the messages to the chat completions endpoint and returns the reply
+func (p *openAICompatibleProvider) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	// Start timing
+	startTime := time.Now()
+	log.Printf("Starting %s API request...", p.name)
 
-	// Create the HTTP request
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	httpReq, err := p.newRequest(ctx, req, false)
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
+		return nil, err
 	}
 
-	// Set the headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
 	// Send the request
-	resp, err := httpClient.Do(req)
+	resp, err := httpClient.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	// Parse the response
-	var response struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-
+	var response OpenAIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("error decoding response: %w", err)
+		return nil, fmt.Errorf("error decoding response: %w", err)
 	}
 
 	// Check if there are any choices
 	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned")
+		return nil, fmt.Errorf("no response choices returned")
 	}
 
 	// Calculate and log the elapsed time
-	elapsedTime := time.Since(startTime)
-	log.Printf("OpenAI API request completed in %v", elapsedTime)
+	log.Printf("%s API request completed in %v", p.name, time.Since(startTime))
 
-	// Return the content of the first choice
-	return response.Choices[0].Message.Content, nil
+	// Return the content and tool calls of the first choice
+	choice := response.Choices[0].Message
+	return &CompletionResponse{Content: choice.Content, ToolCalls: fromOpenAIToolCalls(choice.ToolCalls)}, nil
 }
 
-// AddMessage is a helper function to add a message to the history
-func AddMessage(agentID, role, content string) {
-	// This function would typically store the message in a database
-	// For now, we'll just log it
-	fmt.Printf("Adding message to history for agent %s: %s: %s\n", agentID, role, content)
+// Stream sends the messages with streaming enabled, calling onDelta for every content
+// fragment as it arrives. The returned response carries the complete reply.
+func (p *openAICompatibleProvider) Stream(ctx context.Context, req CompletionRequest, onDelta func(delta string) error) (*CompletionResponse, error) {
+	startTime := time.Now()
+	log.Printf("Starting %s streaming API request...", p.name)
+
+	// Give up on the upstream when it stalls or the caller goes away
+	watchdog := newStreamWatchdog(ctx, streamIdleTimeout)
+	defer watchdog.stop()
+
+	httpReq, err := p.newRequest(watchdog.ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := streamClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", watchdog.err(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	// Relay each delta and accumulate the full reply and any tool calls
+	var content strings.Builder
+	var toolCalls []OpenAIToolCall
+	err = readServerSentEvents(watchdog.body(resp.Body), func(event, data string) error {
+		if data == "[DONE]" {
+			return errStreamDone
+		}
+
+		var chunk OpenAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("error decoding stream chunk: %w", err)
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+
+		delta := chunk.Choices[0].Delta
+		for _, fragment := range delta.ToolCalls {
+			if fragment.Index < 0 || fragment.Index > maxStreamToolCalls {
+				return fmt.Errorf("invalid tool call index %d in stream", fragment.Index)
+			}
+			for len(toolCalls) <= fragment.Index {
+				toolCalls = append(toolCalls, OpenAIToolCall{Index: len(toolCalls)})
+			}
+			call := &toolCalls[fragment.Index]
+			if fragment.ID != "" {
+				call.ID = fragment.ID
+			}
+			if fragment.Function.Name != "" {
+				call.Function.Name = fragment.Function.Name
+			}
+			call.Function.Arguments += fragment.Function.Arguments
+		}
+
+		if delta.Content == "" {
+			return nil
+		}
+		content.WriteString(delta.Content)
+		return onDelta(delta.Content)
+	})
+	if err != nil && err != errStreamDone {
+		return nil, watchdog.err(err)
+	}
+
+	log.Printf("%s streaming API request completed in %v", p.name, time.Since(startTime))
+
+	return &CompletionResponse{Content: content.String(), ToolCalls: fromOpenAIToolCalls(toolCalls)}, nil
+}
+
+// newRequest builds the HTTP request for a chat completion
+func (p *openAICompatibleProvider) newRequest(ctx context.Context, req CompletionRequest, stream bool) (*http.Request, error) {
+	if p.requireKey && p.apiKey == "" {
+		return nil, fmt.Errorf("%s environment variable is not set", p.keyVariable)
+	}
+
+	// Create the request payload
+	requestBody := OpenAIRequest{
+		Model:            req.model(p.model),
+		Messages:         toOpenAIMessages(req.Messages),
+		Temperature:      req.temperature(),
+		TopP:             req.Params.TopP,
+		MaxTokens:        req.Params.MaxTokens,
+		Stop:             req.Params.Stop,
+		PresencePenalty:  req.Params.PresencePenalty,
+		FrequencyPenalty: req.Params.FrequencyPenalty,
+		Seed:             req.Params.Seed,
+		Stream:           stream,
+	}
+	for _, def := range req.Tools {
+		requestBody.Tools = append(requestBody.Tools, OpenAITool{Type: "function", Function: def})
+	}
+
+	// Convert the request payload to JSON
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	// Create the HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	// Set the headers
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	return httpReq, nil
+}
+
+// toOpenAIMessages converts chat messages to the OpenAI wire format
+func toOpenAIMessages(messages []ChatMessage) []OpenAIMessage {
+	converted := make([]OpenAIMessage, 0, len(messages))
+	for _, msg := range messages {
+		wire := OpenAIMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+		}
+		for i, call := range msg.ToolCalls {
+			toolCall := OpenAIToolCall{Index: i, ID: call.ID, Type: "function"}
+			toolCall.Function.Name = call.Name
+			toolCall.Function.Arguments = call.Arguments
+			wire.ToolCalls = append(wire.ToolCalls, toolCall)
+		}
+		converted = append(converted, wire)
+	}
+	return converted
+}
+
+// fromOpenAIToolCalls converts tool calls in the OpenAI wire format to tools.Call
+func fromOpenAIToolCalls(toolCalls []OpenAIToolCall) []tools.Call {
+	var calls []tools.Call
+	for _, call := range toolCalls {
+		calls = append(calls, tools.Call{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	return calls
 }
@@ -1,35 +1,254 @@
 package services
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"ai-agent-app/models"
+	"ai-agent-app/services/tools"
 )
 
-// LoadPersonality loads a personality from a JSON file
+// ErrInvalidPersonality is wrapped by the errors of ValidatePersonality
+var ErrInvalidPersonality = errors.New("invalid personality")
+
+// personalityIDPattern restricts personality IDs to lowercase slugs usable as file names
+var personalityIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// FindPersonality returns the personality with the given ID. The database is checked
+// first, then the personality files loaded by the registry.
+func FindPersonality(id string) (*models.Personality, error) {
+	stored, err := stores.Personalities.GetByID(id)
+	if err == nil {
+		return stored, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		log.Printf("Warning: Could not look up personality %s in the database: %v", id, err)
+	}
+
+	if personality, ok := Personalities().Get(id); ok {
+		return personality, nil
+	}
+	return nil, fmt.Errorf("personality %s: %w", id, ErrNotFound)
+}
+
+// LoadAgentPersonality returns the personality an agent is bound to. It is an error
+// for the bound personality to be missing. Agents without a binding fall back to
+// LoadPersonality's name matching, which is logged since it hides typos.
+func LoadAgentPersonality(agent *models.Agent) (*models.Personality, error) {
+	if agent.PersonalityID != "" {
+		personality, err := FindPersonality(agent.PersonalityID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("agent %d is bound to personality %q, which does not exist", agent.ID, agent.PersonalityID)
+		}
+		return personality, err
+	}
+
+	log.Printf("Warning: Agent %d (%s) has no personality_id, matching a personality by its name", agent.ID, agent.Name)
+	return LoadPersonality(agent.Name)
+}
+
+// LoadPersonality loads the personality named after an agent. A personality stored in
+// the database under the agent's name wins over the cached JSON files.
 func LoadPersonality(agentName string) (*models.Personality, error) {
-	// Construct the file path
-	filePath := filepath.Join("personalities", fmt.Sprintf("%s.json", agentName))
+	stored, err := stores.Personalities.GetByID(agentName)
+	if err == nil {
+		return stored, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		log.Printf("Warning: Could not look up personality %s in the database: %v", agentName, err)
+	}
+
+	// Match the file named after the agent, falling back to the default personality
+	if personality, ok := Personalities().GetByFileName(agentName); ok {
+		return personality, nil
+	}
+	log.Printf("Warning: No personality file for %q, using the default personality", agentName)
+	if personality, ok := Personalities().GetByFileName("default"); ok {
+		return personality, nil
+	}
+	return nil, fmt.Errorf("no personality file for %q and no default personality in %s", agentName, PersonalityDir)
+}
+
+// ValidatePersonality checks that a personality can be stored and used by an agent
+func ValidatePersonality(personality *models.Personality) error {
+	if !personalityIDPattern.MatchString(personality.ID) {
+		return fmt.Errorf("%w: id %q must use lowercase letters, digits, '-' and '_'", ErrInvalidPersonality, personality.ID)
+	}
+	if strings.TrimSpace(personality.Name) == "" {
+		return fmt.Errorf("%w: %s has no name", ErrInvalidPersonality, personality.ID)
+	}
+	if _, err := GetProvider(personality.Provider); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidPersonality, personality.ID, err)
+	}
+	if _, err := tools.Definitions(personality.Tools); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidPersonality, personality.ID, err)
+	}
+	if err := CheckPromptTemplate(personality); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidPersonality, personality.ID, err)
+	}
+	if err := ValidateModelParams(personality.ModelParams); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidPersonality, personality.ID, err)
+	}
+	return nil
+}
+
+// ValidateModelParams checks generation settings against the ranges providers accept
+func ValidateModelParams(params models.ModelParams) error {
+	ranges := []struct {
+		name     string
+		value    *float64
+		min, max float64
+	}{
+		{"temperature", params.Temperature, 0, 2},
+		{"top_p", params.TopP, 0, 1},
+		{"presence_penalty", params.PresencePenalty, -2, 2},
+		{"frequency_penalty", params.FrequencyPenalty, -2, 2},
+	}
+	for _, r := range ranges {
+		if r.value != nil && (*r.value < r.min || *r.value > r.max) {
+			return fmt.Errorf("%s must be between %v and %v", r.name, r.min, r.max)
+		}
+	}
+	if params.MaxTokens != nil && *params.MaxTokens < 1 {
+		return fmt.Errorf("max_tokens must be positive")
+	}
+	if len(params.Stop) > 4 {
+		return fmt.Errorf("at most 4 stop sequences are allowed")
+	}
+	return nil
+}
+
+// CreatePersonality validates and saves a new personality
+func CreatePersonality(personality *models.Personality) error {
+	if err := ValidatePersonality(personality); err != nil {
+		return err
+	}
+	return stores.Personalities.Create(personality)
+}
 
-	// Read the JSON file
-	data, err := os.ReadFile(filePath)
+// GetPersonalityByID retrieves a stored personality by its ID
+func GetPersonalityByID(id string) (*models.Personality, error) {
+	return stores.Personalities.GetByID(id)
+}
+
+// ListPersonalities returns all stored personalities ordered by ID
+func ListPersonalities() ([]models.Personality, error) {
+	return stores.Personalities.List()
+}
+
+// UpdatePersonality validates and replaces a stored personality
+func UpdatePersonality(personality *models.Personality) error {
+	if err := ValidatePersonality(personality); err != nil {
+		return err
+	}
+	return stores.Personalities.Update(personality)
+}
+
+// DeletePersonality removes a stored personality. Agents bound to it keep their binding
+// and fail to chat until a personality with the same ID exists again.
+func DeletePersonality(id string) error {
+	if err := stores.Personalities.Delete(id); err != nil {
+		return err
+	}
+
+	// The personality is gone at this point, so a failed check is reported but does not
+	// fail the deletion
+	agents, _, err := stores.Agents.List(AgentFilter{PersonalityID: id, IncludeArchived: true})
 	if err != nil {
-		// If the file is not found, load the default personality
-		defaultFilePath := filepath.Join("personalities", "default.json")
-		data, err = os.ReadFile(defaultFilePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read personality file and default file: %w", err)
+		log.Printf("Warning: Deleted personality %s but could not check which agents are bound to it: %v", id, err)
+		return nil
+	}
+	for _, agent := range agents {
+		if agent.PersonalityID != id {
+			continue
+		}
+		if _, err := FindPersonality(id); err != nil {
+			log.Printf("Warning: Agent %d (%s) is bound to deleted personality %s", agent.ID, agent.Name, id)
+		}
+	}
+	return nil
+}
+
+// ReadPersonalityFile reads and validates a personality JSON file
+func ReadPersonalityFile(path string) (*models.Personality, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read personality file: %w", err)
+	}
+	return DecodePersonality(data, path)
+}
+
+// PersonalityLintResult lists the problems found in one personality file
+type PersonalityLintResult struct {
+	Path     string
+	Problems []string
+}
+
+// LintPersonalities checks every personality file in dir against the schema, the
+// known providers and tools, and for IDs used by more than one file
+func LintPersonalities(dir string) ([]PersonalityLintResult, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PersonalityLintResult, 0, len(paths))
+	seen := make(map[string]string)
+	for _, path := range paths {
+		result := PersonalityLintResult{Path: path}
+
+		personality, err := ReadPersonalityFile(path)
+		var personalityErr *PersonalityError
+		switch {
+		case errors.As(err, &personalityErr):
+			for _, problem := range personalityErr.Problems {
+				result.Problems = append(result.Problems, problem.String())
+			}
+		case err != nil:
+			result.Problems = append(result.Problems, err.Error())
+		default:
+			if err := ValidatePersonality(personality); err != nil {
+				result.Problems = append(result.Problems, err.Error())
+			}
+			if other, ok := seen[personality.ID]; ok {
+				result.Problems = append(result.Problems, fmt.Sprintf("id %q is also used by %s", personality.ID, other))
+			}
+			seen[personality.ID] = path
 		}
+
+		results = append(results, result)
 	}
+	return results, nil
+}
 
-	// Parse the JSON data
-	var personality models.Personality
-	if err := json.Unmarshal(data, &personality); err != nil {
-		return nil, fmt.Errorf("failed to parse personality data: %w", err)
+// ImportPersonalities stores every personality file in dir, creating new personalities
+// and replacing existing ones with the same ID. It returns the imported IDs.
+func ImportPersonalities(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
 	}
 
-	return &personality, nil
+	var imported []string
+	for _, path := range paths {
+		personality, err := ReadPersonalityFile(path)
+		if err != nil {
+			return imported, err
+		}
+
+		err = CreatePersonality(personality)
+		if errors.Is(err, ErrConflict) {
+			err = UpdatePersonality(personality)
+		}
+		if err != nil {
+			return imported, fmt.Errorf("failed to import %s: %w", path, err)
+		}
+		imported = append(imported, personality.ID)
+	}
+	return imported, nil
 }
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ai-agent-app/models"
+)
+
+// PersonalityDir is the directory personality files are loaded from
+const PersonalityDir = "personalities"
+
+// LoadedPersonality describes a personality file held by the registry
+type LoadedPersonality struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	File string `json:"file"`
+	Hash string `json:"hash"`
+}
+
+// PersonalitySetInfo describes the set of personality files currently in use
+type PersonalitySetInfo struct {
+	Version       int                 `json:"version"`
+	Hash          string              `json:"hash"`
+	LoadedAt      time.Time           `json:"loaded_at"`
+	Personalities []LoadedPersonality `json:"personalities"`
+}
+
+// personalitySet is an immutable snapshot of the personality directory
+type personalitySet struct {
+	info   PersonalitySetInfo
+	byID   map[string]*models.Personality
+	byFile map[string]*models.Personality // Keyed by file name without extension
+	stamp  string                         // File names, sizes and modification times
+}
+
+// PersonalityRegistry loads the personality files of a directory once and serves them
+// from memory. Watch polls the directory and swaps in a new snapshot when files change;
+// readers always see either the old or the new set, never a mix.
+type PersonalityRegistry struct {
+	dir         string
+	current     atomic.Pointer[personalitySet]
+	reload      sync.Mutex
+	failedStamp string // State of the directory the last failed reload saw, so it is reported once
+}
+
+// NewPersonalityRegistry creates a registry for dir and loads it. Invalid files are
+// skipped with a warning so one broken persona does not take the others down.
+func NewPersonalityRegistry(dir string) *PersonalityRegistry {
+	registry := &PersonalityRegistry{dir: dir}
+	if _, err := registry.Reload(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return registry
+}
+
+// Get returns the personality with the given ID
+func (r *PersonalityRegistry) Get(id string) (*models.Personality, bool) {
+	personality, ok := r.current.Load().byID[id]
+	return personality, ok
+}
+
+// GetByFileName returns the personality loaded from <name>.json
+func (r *PersonalityRegistry) GetByFileName(name string) (*models.Personality, bool) {
+	personality, ok := r.current.Load().byFile[name]
+	return personality, ok
+}
+
+// Info describes the loaded set
+func (r *PersonalityRegistry) Info() PersonalitySetInfo {
+	return r.current.Load().info
+}
+
+// Reload re-reads the directory if it changed since the last load and reports whether
+// a new set was installed. If any file fails to load the current set is kept, except
+// on the first load where the valid files are used.
+func (r *PersonalityRegistry) Reload() (bool, error) {
+	r.reload.Lock()
+	defer r.reload.Unlock()
+
+	previous := r.current.Load()
+	stamp, err := r.stamp()
+	if err != nil {
+		return false, fmt.Errorf("failed to scan personality directory %s: %w", r.dir, err)
+	}
+	if previous != nil && (stamp == previous.stamp || stamp == r.failedStamp) {
+		return false, nil
+	}
+
+	next, problems := r.load(stamp)
+	if len(problems) > 0 && previous != nil {
+		r.failedStamp = stamp
+		return false, fmt.Errorf("keeping personality set version %d, reload failed: %s", previous.info.Version, strings.Join(problems, "; "))
+	}
+
+	if previous != nil {
+		if next.info.Hash == previous.info.Hash {
+			// Files were touched but their contents are unchanged
+			next.info = previous.info
+			r.current.Store(next)
+			return false, nil
+		}
+		next.info.Version = previous.info.Version + 1
+	}
+	r.current.Store(next)
+
+	if len(problems) > 0 {
+		return true, fmt.Errorf("skipped invalid personality files: %s", strings.Join(problems, "; "))
+	}
+	return true, nil
+}
+
+// Watch polls the directory every interval and reloads it on changes until stop is closed
+func (r *PersonalityRegistry) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			changed, err := r.Reload()
+			if err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			if changed {
+				info := r.Info()
+				log.Printf("Reloaded %d personalities (version %d, hash %s)", len(info.Personalities), info.Version, info.Hash)
+			}
+		}
+	}
+}
+
+// stamp summarizes the names, sizes and modification times of the personality files
+func (r *PersonalityRegistry) stamp() (string, error) {
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return "", err
+	}
+
+	var stamp strings.Builder
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			// The file was removed between listing and stat; the next poll catches up
+			continue
+		}
+		fmt.Fprintf(&stamp, "%s:%d:%d\n", path, stat.Size(), stat.ModTime().UnixNano())
+	}
+	return stamp.String(), nil
+}
+
+// load reads every personality file into a new set, returning the problems of files
+// that could not be used
+func (r *PersonalityRegistry) load(stamp string) (*personalitySet, []string) {
+	set := &personalitySet{
+		info:   PersonalitySetInfo{Version: 1, LoadedAt: time.Now()},
+		byID:   make(map[string]*models.Personality),
+		byFile: make(map[string]*models.Personality),
+		stamp:  stamp,
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	sort.Strings(paths)
+
+	var problems []string
+	files := make(map[string]string)
+	setHash := sha256.New()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		personality, err := DecodePersonality(data, path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if other, ok := files[personality.ID]; ok {
+			problems = append(problems, fmt.Sprintf("%s: id %q is already used by %s", path, personality.ID, other))
+			continue
+		}
+		files[personality.ID] = path
+
+		sum := sha256.Sum256(data)
+		fileHash := hex.EncodeToString(sum[:])[:12]
+		fmt.Fprintf(setHash, "%s %s\n", filepath.Base(path), fileHash)
+
+		set.byID[personality.ID] = personality
+		set.byFile[strings.TrimSuffix(filepath.Base(path), ".json")] = personality
+		set.info.Personalities = append(set.info.Personalities, LoadedPersonality{
+			ID:   personality.ID,
+			Name: personality.Name,
+			File: path,
+			Hash: fileHash,
+		})
+	}
+	set.info.Hash = hex.EncodeToString(setHash.Sum(nil))[:12]
+
+	return set, problems
+}
+
+var (
+	personalityRegistry     *PersonalityRegistry
+	personalityRegistryOnce sync.Once
+)
+
+// Personalities returns the registry of the personality files, loading it on first use
+func Personalities() *PersonalityRegistry {
+	personalityRegistryOnce.Do(func() {
+		personalityRegistry = NewPersonalityRegistry(PersonalityDir)
+	})
+	return personalityRegistry
+}
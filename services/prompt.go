@@ -0,0 +1,352 @@
+package services
+
+import (
+	"fmt"
+
+	"ai-agent-app/models"
+	"ai-agent-app/services/tools"
+)
+
+// DefaultReplyTokens is the part of the context window kept free for the model's answer
+const DefaultReplyTokens = 1024
+
+// DefaultPromptTemplate lays out the system prompt of personalities without their own
+// prompt_template. Templates use text/template syntax over the fields of PromptData.
+const DefaultPromptTemplate = `You are {{.Name}}, an AI assistant.
+{{.Description}}
+{{.System}}
+{{if .Bio}}
+Background:
+{{range .Bio}}{{.}}
+{{end}}{{end}}{{if .Lore}}
+Experience:
+{{range .Lore}}{{.}}
+{{end}}{{end}}{{if .Knowledge}}
+Expertise:
+{{range .Knowledge}}{{.}}
+{{end}}{{end}}{{if .Style}}
+Communication style:
+{{range .Style}}{{.}}
+{{end}}{{end}}{{if .Adjectives}}
+Adjectives:
+{{join .Adjectives ", "}}
+{{end}}
+Summary of earlier conversation:
+{{if .Summary}}{{.Summary}}{{else}}No earlier conversation.{{end}}
+
+Relevant past conversations:
+{{range .Memories}}{{.Role}}: {{.Content}}
+{{else}}No related past conversations.
+{{end}}
+Instructions:
+{{.Instructions}}
+`
+
+// PromptData is what prompt templates are rendered with. Lists hold only the items
+// that fit the budget; History repeats the turns sent after the system prompt.
+type PromptData struct {
+	Name         string
+	Description  string
+	System       string
+	Bio          []string
+	Lore         []string
+	Knowledge    []string
+	Style        []string
+	Adjectives   []string
+	Instructions string
+	Summary      string
+	Memories     []Message
+	History      []Message
+}
+
+// vars returns the data as ContextBuilder variables
+func (d PromptData) vars() map[string]interface{} {
+	return map[string]interface{}{
+		"Name":         d.Name,
+		"Description":  d.Description,
+		"System":       d.System,
+		"Bio":          d.Bio,
+		"Lore":         d.Lore,
+		"Knowledge":    d.Knowledge,
+		"Style":        d.Style,
+		"Adjectives":   d.Adjectives,
+		"Instructions": d.Instructions,
+		"Summary":      d.Summary,
+		"Memories":     d.Memories,
+		"History":      d.History,
+	}
+}
+
+// promptTemplate returns the template a personality's prompt is rendered with
+func promptTemplate(personality *models.Personality) string {
+	if personality.PromptTemplate != "" {
+		return personality.PromptTemplate
+	}
+	return DefaultPromptTemplate
+}
+
+// CheckPromptTemplate parses a personality's prompt template and renders it with sample
+// data, so syntax errors and unknown fields are reported before the template is used
+func CheckPromptTemplate(personality *models.Personality) error {
+	if personality.PromptTemplate == "" {
+		return nil
+	}
+
+	sample := PromptData{
+		Name:         personality.Name,
+		Bio:          []string{"bio"},
+		Lore:         []string{"lore"},
+		Knowledge:    []string{"knowledge"},
+		Style:        []string{"style"},
+		Adjectives:   []string{"adjective"},
+		Instructions: personality.Instructions,
+		Summary:      "summary",
+		Memories:     []Message{{Role: "user", Content: "memory"}},
+		History:      []Message{{Role: "user", Content: "hello"}, {Role: "assistant", Content: "hi"}},
+	}
+	if _, err := NewContextBuilder(personality.PromptTemplate).WithVars(sample.vars()).Build(); err != nil {
+		return fmt.Errorf("invalid prompt_template: %w", err)
+	}
+	return nil
+}
+
+// PromptBudget splits the tokens left after the fixed parts of the prompt between
+// the trimmable sections. Shares are fractions and should add up to 1; tokens a
+// section does not use are handed to the others in priority order.
+type PromptBudget struct {
+	History  float64
+	Summary  float64
+	Persona  float64
+	Memories float64
+}
+
+// DefaultPromptBudget favours recent turns, then persona details, memories and the summary
+var DefaultPromptBudget = PromptBudget{History: 0.4, Persona: 0.25, Memories: 0.2, Summary: 0.15}
+
+// PromptInput holds everything that may go into a prompt
+type PromptInput struct {
+	Personality *models.Personality
+	Summary     string
+	Memories    []Message // Retrieved by similarity, most relevant first
+	History     []Message // Recent turns in chronological order
+	Message     string    // The new user input
+	Tools       []tools.Definition
+}
+
+// PromptSection reports how a section of the prompt was budgeted
+type PromptSection struct {
+	Budget  int `json:"budget"`
+	Tokens  int `json:"tokens"`
+	Kept    int `json:"kept"`
+	Dropped int `json:"dropped"`
+}
+
+// AssembledPrompt is the message list sent to the provider together with its token accounting
+type AssembledPrompt struct {
+	Messages      []ChatMessage
+	Tokens        int
+	ContextWindow int
+	Sections      map[string]PromptSection
+}
+
+// PromptAssembler builds prompts that fit a model's context window
+type PromptAssembler struct {
+	ContextWindow int
+	ReplyTokens   int
+	Budget        PromptBudget
+}
+
+// NewPromptAssembler creates an assembler for the given model with the default budget.
+// maxTokens, when set, replaces DefaultReplyTokens as the room kept for the answer.
+func NewPromptAssembler(model string, maxTokens *int) *PromptAssembler {
+	assembler := &PromptAssembler{
+		ContextWindow: ModelContextWindow(model),
+		ReplyTokens:   DefaultReplyTokens,
+		Budget:        DefaultPromptBudget,
+	}
+	if maxTokens != nil {
+		assembler.ReplyTokens = *maxTokens
+	}
+	return assembler
+}
+
+// promptItem is a single droppable piece of a section
+type promptItem struct {
+	text    string
+	message Message // Set for history turns and memories
+	tokens  int
+}
+
+// promptSection collects the items of one section in priority order
+type promptSection struct {
+	name   string
+	share  float64
+	items  []promptItem
+	budget int
+	used   int
+	kept   int
+}
+
+// fill keeps items in order while they fit the remaining budget and returns the tokens taken.
+// It stops at the first item that does not fit, so recent history keeps no gaps and
+// memories and persona details are only dropped from the least important end.
+func (s *promptSection) fill(available int) int {
+	taken := 0
+	for i := s.kept; i < len(s.items); i++ {
+		if s.items[i].tokens > available-taken {
+			break
+		}
+		taken += s.items[i].tokens
+		s.kept++
+	}
+	s.used += taken
+	return taken
+}
+
+// Assemble renders the prompt for input with the personality's template. The persona's
+// name, description, system prompt and instructions, the tool definitions and the new
+// message are always included; persona details, the summary, memories and recent
+// history are trimmed to fit the window.
+func (a *PromptAssembler) Assemble(input PromptInput) (AssembledPrompt, error) {
+	p := input.Personality
+	builder := NewContextBuilder(promptTemplate(p))
+
+	// Fixed parts: the template with empty sections, the new message and the tool definitions
+	data := PromptData{
+		Name:         p.Name,
+		Description:  p.Description,
+		System:       p.System,
+		Instructions: p.Instructions,
+	}
+	skeleton, err := builder.WithVars(data.vars()).Build()
+	if err != nil {
+		return AssembledPrompt{}, fmt.Errorf("error rendering prompt template of %s: %w", p.ID, err)
+	}
+	fixed := CountMessageTokens([]ChatMessage{
+		{Role: "system", Content: skeleton},
+		{Role: "user", Content: input.Message},
+	})
+	if len(input.Tools) > 0 {
+		fixed += countJSONTokens(input.Tools)
+	}
+	available := a.ContextWindow - a.ReplyTokens - fixed
+	if available < 0 {
+		available = 0
+	}
+
+	// Persona details are ordered so the least important (adjectives, lore) are dropped first
+	persona := &promptSection{name: "persona", share: a.Budget.Persona}
+	personaKinds := []struct {
+		target *[]string
+		lines  []string
+	}{
+		{&data.Bio, p.Bio},
+		{&data.Style, append(append([]string{}, p.Style.All...), p.Style.Chat...)},
+		{&data.Knowledge, p.Knowledge},
+		{&data.Lore, p.Lore},
+		{&data.Adjectives, p.Adjectives},
+	}
+	personaTargets := make([]*[]string, 0)
+	for _, kind := range personaKinds {
+		for _, line := range kind.lines {
+			persona.items = append(persona.items, promptItem{text: line, tokens: CountTokens(line) + 1})
+			personaTargets = append(personaTargets, kind.target)
+		}
+	}
+
+	summary := &promptSection{name: "summary", share: a.Budget.Summary}
+	if input.Summary != "" {
+		summary.items = []promptItem{{text: input.Summary, tokens: CountTokens(input.Summary)}}
+	}
+
+	// Recent history is filled newest first, memories most relevant first
+	history := &promptSection{name: "history", share: a.Budget.History}
+	inHistory := make(map[int]bool)
+	for i := len(input.History) - 1; i >= 0; i-- {
+		msg := input.History[i]
+		// Only conversational turns are replayed to the model
+		if msg.Role != "user" && msg.Role != "assistant" {
+			continue
+		}
+		history.items = append(history.items, promptItem{message: msg, tokens: messageTokenOverhead + CountTokens(msg.Content)})
+		if msg.ID != 0 {
+			inHistory[msg.ID] = true
+		}
+	}
+
+	memories := &promptSection{name: "memories", share: a.Budget.Memories}
+	for _, msg := range input.Memories {
+		// A memory that is already part of the recent turns adds nothing
+		if msg.ID != 0 && inHistory[msg.ID] {
+			continue
+		}
+		line := fmt.Sprintf("%s: %s", msg.Role, msg.Content)
+		memories.items = append(memories.items, promptItem{message: msg, tokens: CountTokens(line) + 1})
+	}
+
+	// First pass: every section fills its own share. Second pass: leftover tokens go
+	// to the sections in priority order.
+	sections := []*promptSection{history, summary, persona, memories}
+	remaining := available
+	for _, s := range sections {
+		s.budget = int(float64(available) * s.share)
+		remaining -= s.fill(s.budget)
+	}
+	for _, s := range sections {
+		extra := s.fill(remaining)
+		s.budget += extra
+		remaining -= extra
+	}
+
+	// A summary too long for any budget is truncated rather than dropped
+	if summary.kept > 0 {
+		data.Summary = input.Summary
+	} else if input.Summary != "" && remaining > 0 {
+		data.Summary = TruncateToTokens(input.Summary, remaining)
+		summary.used = CountTokens(data.Summary)
+		summary.budget += summary.used
+		remaining -= summary.used
+		summary.kept = 1
+	}
+
+	// Render the system prompt from the kept items
+	for i := 0; i < persona.kept; i++ {
+		*personaTargets[i] = append(*personaTargets[i], persona.items[i].text)
+	}
+	for i := 0; i < memories.kept; i++ {
+		data.Memories = append(data.Memories, memories.items[i].message)
+	}
+	// History items were collected newest first, so replay them in reverse
+	for i := history.kept - 1; i >= 0; i-- {
+		data.History = append(data.History, history.items[i].message)
+	}
+
+	systemPrompt, err := builder.WithVars(data.vars()).Build()
+	if err != nil {
+		return AssembledPrompt{}, fmt.Errorf("error rendering prompt template of %s: %w", p.ID, err)
+	}
+
+	messages := make([]ChatMessage, 0, len(data.History)+2)
+	messages = append(messages, ChatMessage{Role: "system", Content: systemPrompt})
+	for _, msg := range data.History {
+		messages = append(messages, ChatMessage{Role: msg.Role, Content: msg.Content})
+	}
+	messages = append(messages, ChatMessage{Role: "user", Content: input.Message})
+
+	report := make(map[string]PromptSection, len(sections))
+	for _, s := range sections {
+		report[s.name] = PromptSection{Budget: s.budget, Tokens: s.used, Kept: s.kept, Dropped: len(s.items) - s.kept}
+	}
+
+	tokens := CountMessageTokens(messages)
+	if len(input.Tools) > 0 {
+		tokens += countJSONTokens(input.Tools)
+	}
+
+	return AssembledPrompt{
+		Messages:      messages,
+		Tokens:        tokens,
+		ContextWindow: a.ContextWindow,
+		Sections:      report,
+	}, nil
+}
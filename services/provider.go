@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"ai-agent-app/models"
+	"ai-agent-app/services/tools"
+)
+
+// DefaultProvider is used when a personality does not name a provider
+const DefaultProvider = "openai"
+
+// ChatMessage is a single role-tagged message sent to a chat provider
+type ChatMessage struct {
+	Role       string       `json:"role"`                   // "system", "user", "assistant" or "tool"
+	Content    string       `json:"content"`                // The message content
+	ToolCalls  []tools.Call `json:"tool_calls,omitempty"`   // Tools requested by an assistant message
+	ToolCallID string       `json:"tool_call_id,omitempty"` // The call a "tool" message answers
+	Name       string       `json:"name,omitempty"`         // The tool a "tool" message comes from
+}
+
+// CompletionRequest is the provider-neutral request built by the chat pipeline
+type CompletionRequest struct {
+	Messages []ChatMessage
+	Tools    []tools.Definition // Tools the model may call, if any
+	Params   models.ModelParams // Generation settings; unset fields use the provider's defaults
+}
+
+// CompletionResponse is the provider-neutral reply to a CompletionRequest.
+// When ToolCalls is not empty the model is waiting for their results.
+type CompletionResponse struct {
+	Content   string
+	ToolCalls []tools.Call
+}
+
+// ChatProvider is implemented by every LLM backend an agent can be served by
+type ChatProvider interface {
+	// Name returns the provider identifier used in personality files
+	Name() string
+	// Model returns the model the provider sends requests to
+	Model() string
+	// Complete sends the conversation to the backend and returns its reply. Cancelling
+	// ctx, e.g. when the client that asked for the reply disconnects, aborts the call.
+	Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error)
+	// Stream behaves like Complete but calls onDelta with each fragment of the reply
+	// as it arrives. Returning an error from onDelta aborts the stream.
+	Stream(ctx context.Context, req CompletionRequest, onDelta func(delta string) error) (*CompletionResponse, error)
+}
+
+// defaultTemperature is sent when neither the personality nor the request sets one
+const defaultTemperature = 0.7
+
+// temperature returns the requested sampling temperature or the default
+func (r CompletionRequest) temperature() float64 {
+	if r.Params.Temperature != nil {
+		return *r.Params.Temperature
+	}
+	return defaultTemperature
+}
+
+// model returns the requested model, or fallback when the request does not override it
+func (r CompletionRequest) model(fallback string) string {
+	if r.Params.Model != "" {
+		return r.Params.Model
+	}
+	return fallback
+}
+
+// errStreamDone is returned from stream callbacks when the provider signals the end of a reply
+var errStreamDone = errors.New("stream done")
+
+// GetProvider returns the chat provider registered under the given name.
+// Credentials, endpoints and models are read from the environment.
+func GetProvider(name string) (ChatProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "openai":
+		return NewOpenAIProvider(os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_MODEL")), nil
+	case "grok", "xai":
+		return NewGrokProvider(os.Getenv("GROK_API_KEY"), os.Getenv("GROK_MODEL")), nil
+	case "anthropic", "claude":
+		return NewAnthropicProvider(os.Getenv("ANTHROPIC_API_KEY"), os.Getenv("ANTHROPIC_MODEL")), nil
+	case "local", "ollama", "llamacpp", "llama.cpp":
+		return NewLocalProvider(os.Getenv("LOCAL_LLM_URL"), os.Getenv("LOCAL_LLM_API_KEY"), os.Getenv("LOCAL_LLM_MODEL")), nil
+	default:
+		return nil, fmt.Errorf("unknown chat provider %q", name)
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Default reciprocal rank fusion settings
+const (
+	DefaultVectorWeight  = 1.0
+	DefaultLexicalWeight = 1.0
+	DefaultRRFK          = 60
+)
+
+// retrievalCandidates is how many results each retriever contributes per requested result,
+// so that messages ranked low by one retriever can still be lifted by the other
+const retrievalCandidates = 4
+
+// RetrievalWeights controls how vector and full-text results are fused. A weight of 0
+// disables that retriever.
+type RetrievalWeights struct {
+	Vector  float64
+	Lexical float64
+	K       int // Rank constant of reciprocal rank fusion; larger values flatten the ranking
+}
+
+// DefaultRetrievalWeights returns the fusion settings from RETRIEVAL_VECTOR_WEIGHT,
+// RETRIEVAL_LEXICAL_WEIGHT and RETRIEVAL_RRF_K, falling back to the defaults
+func DefaultRetrievalWeights() RetrievalWeights {
+	weights := RetrievalWeights{Vector: DefaultVectorWeight, Lexical: DefaultLexicalWeight, K: DefaultRRFK}
+	if value, err := strconv.ParseFloat(os.Getenv("RETRIEVAL_VECTOR_WEIGHT"), 64); err == nil && value >= 0 {
+		weights.Vector = value
+	}
+	if value, err := strconv.ParseFloat(os.Getenv("RETRIEVAL_LEXICAL_WEIGHT"), 64); err == nil && value >= 0 {
+		weights.Lexical = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("RETRIEVAL_RRF_K")); err == nil && value > 0 {
+		weights.K = value
+	}
+	return weights
+}
+
+// FuseResults merges the vector and full-text rankings with weighted reciprocal rank
+// fusion: a message scores weight/(k+rank) in each ranking it appears in. The fused score
+// is stored in Score, and the vector similarity and text rank of each message are kept.
+// At most limit results are returned, best first.
+func FuseResults(vector, lexical []ScoredMessage, weights RetrievalWeights, limit int) []ScoredMessage {
+	k := weights.K
+	if k <= 0 {
+		k = DefaultRRFK
+	}
+
+	fused := map[int]*ScoredMessage{}
+	var order []int
+	add := func(results []ScoredMessage, weight float64, merge func(into *ScoredMessage, from ScoredMessage)) {
+		if weight == 0 {
+			return
+		}
+		for rank, result := range results {
+			entry, ok := fused[result.ID]
+			if !ok {
+				entry = &ScoredMessage{Message: result.Message}
+				fused[result.ID] = entry
+				order = append(order, result.ID)
+			}
+			entry.Score += weight / float64(k+rank+1)
+			merge(entry, result)
+		}
+	}
+	add(vector, weights.Vector, func(into *ScoredMessage, from ScoredMessage) { into.Similarity = from.Similarity })
+	add(lexical, weights.Lexical, func(into *ScoredMessage, from ScoredMessage) { into.TextRank = from.TextRank })
+
+	results := make([]ScoredMessage, 0, len(order))
+	for _, id := range order {
+		results = append(results, *fused[id])
+	}
+	// Ties keep the vector ranking first, then the text ranking
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// textTerms splits text into lower-case words of letters and digits, so that names,
+// error codes and ticket numbers match however they are punctuated
+func textTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// textQuery returns a Postgres tsquery matching messages that contain any of the words of
+// the text, or an empty string if it has none
+func textQuery(text string) string {
+	return strings.Join(textTerms(text), " | ")
+}
+
+// rankText scores documents against the words of a query like a simplified BM25: each
+// query word found in a document adds its inverse document frequency, damped by how often
+// it occurs. Documents without any query word score 0.
+func rankText(query string, documents []string) []float32 {
+	queryTerms := map[string]bool{}
+	for _, term := range textTerms(query) {
+		queryTerms[term] = true
+	}
+
+	counts := make([]map[string]int, len(documents))
+	documentFrequency := map[string]int{}
+	for i, document := range documents {
+		counts[i] = map[string]int{}
+		for _, term := range textTerms(document) {
+			if queryTerms[term] {
+				if counts[i][term] == 0 {
+					documentFrequency[term]++
+				}
+				counts[i][term]++
+			}
+		}
+	}
+
+	scores := make([]float32, len(documents))
+	for i := range documents {
+		var score float64
+		for term, count := range counts[i] {
+			idf := math.Log(1 + float64(len(documents))/float64(documentFrequency[term]))
+			score += idf * float64(count) / (float64(count) + 1.2)
+		}
+		scores[i] = float32(score)
+	}
+	return scores
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"ai-agent-app/models"
+)
+
+// jsonSchema is the subset of JSON Schema used by models.PersonalitySchema:
+// type, required, properties, additionalProperties, items, maxItems, pattern,
+// minLength, minimum, maximum and local $ref into $defs
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MaxItems             *int                   `json:"maxItems"`
+	Pattern              string                 `json:"pattern"`
+	MinLength            int                    `json:"minLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+
+	pattern *regexp.Regexp
+}
+
+// SchemaProblem is a single schema violation at a JSON path such as $.style.chat[2]
+type SchemaProblem struct {
+	Path    string
+	Message string
+}
+
+func (p SchemaProblem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// PersonalityError lists everything wrong with a personality document
+type PersonalityError struct {
+	Source   string
+	Problems []SchemaProblem
+}
+
+func (e *PersonalityError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.String()
+	}
+	return fmt.Sprintf("%v: %s: %s", ErrInvalidPersonality, e.Source, strings.Join(problems, "; "))
+}
+
+// Unwrap lets errors.Is match ErrInvalidPersonality
+func (e *PersonalityError) Unwrap() error {
+	return ErrInvalidPersonality
+}
+
+var (
+	personalitySchemaOnce sync.Once
+	personalitySchema     *jsonSchema
+	personalitySchemaErr  error
+)
+
+// loadPersonalitySchema parses the embedded schema and compiles its patterns once
+func loadPersonalitySchema() (*jsonSchema, error) {
+	personalitySchemaOnce.Do(func() {
+		var schema jsonSchema
+		if err := json.Unmarshal(models.PersonalitySchema, &schema); err != nil {
+			personalitySchemaErr = fmt.Errorf("invalid personality schema: %w", err)
+			return
+		}
+		personalitySchemaErr = schema.compile(&schema)
+		personalitySchema = &schema
+	})
+	return personalitySchema, personalitySchemaErr
+}
+
+// compile resolves references against root and compiles patterns
+func (s *jsonSchema) compile(root *jsonSchema) error {
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		target := root.Defs[name]
+		if !ok || target == nil {
+			return fmt.Errorf("unresolvable schema reference %q", s.Ref)
+		}
+		// Keywords next to $ref still apply on top of the referenced schema
+		maxItems := s.MaxItems
+		*s = *target
+		if maxItems != nil {
+			s.MaxItems = maxItems
+		}
+		if s.Ref != "" {
+			return s.compile(root)
+		}
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+	for _, child := range s.Properties {
+		if err := child.compile(root); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(root)
+	}
+	return nil
+}
+
+// validate appends the violations of value against the schema to problems
+func (s *jsonSchema) validate(path string, value interface{}, problems []SchemaProblem) []SchemaProblem {
+	if s.Type != "" && !hasJSONType(value, s.Type) {
+		return append(problems, SchemaProblem{path, fmt.Sprintf("expected %s, got %s", s.Type, jsonType(value))})
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				problems = append(problems, SchemaProblem{path, fmt.Sprintf("missing required field %q", key)})
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child, ok := s.Properties[key]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					problems = append(problems, SchemaProblem{path, fmt.Sprintf("unknown field %q", key)})
+				}
+				continue
+			}
+			problems = child.validate(path+"."+key, v[key], problems)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			problems = append(problems, SchemaProblem{path, fmt.Sprintf("must be at least %v", *s.Minimum)})
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			problems = append(problems, SchemaProblem{path, fmt.Sprintf("must be at most %v", *s.Maximum)})
+		}
+	case []interface{}:
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			problems = append(problems, SchemaProblem{path, fmt.Sprintf("must have at most %d items", *s.MaxItems)})
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				problems = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+	case string:
+		if len([]rune(v)) < s.MinLength {
+			problems = append(problems, SchemaProblem{path, fmt.Sprintf("must be at least %d characters", s.MinLength)})
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			problems = append(problems, SchemaProblem{path, fmt.Sprintf("%q does not match %s", v, s.Pattern)})
+		}
+	}
+	return problems
+}
+
+// hasJSONType reports whether a value decoded by encoding/json is of the schema type;
+// integers are numbers without a fractional part
+func hasJSONType(value interface{}, schemaType string) bool {
+	if number, ok := value.(float64); ok && schemaType == "integer" {
+		return number == math.Trunc(number)
+	}
+	return jsonType(value) == schemaType
+}
+
+// jsonType names the JSON type of a value decoded by encoding/json
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// DecodePersonality validates a personality document against models.PersonalitySchema,
+// decodes it strictly and checks its prompt template. Every schema violation is reported in a *PersonalityError;
+// source names the document in messages, e.g. its file path.
+func DecodePersonality(data []byte, source string) (*models.Personality, error) {
+	schema, err := loadPersonalitySchema()
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := lineAndColumn(data, syntaxErr.Offset)
+			return nil, &PersonalityError{Source: source, Problems: []SchemaProblem{{fmt.Sprintf("line %d, column %d", line, column), syntaxErr.Error()}}}
+		}
+		return nil, &PersonalityError{Source: source, Problems: []SchemaProblem{{"$", err.Error()}}}
+	}
+	if problems := schema.validate("$", document, nil); len(problems) > 0 {
+		return nil, &PersonalityError{Source: source, Problems: problems}
+	}
+
+	// The schema mirrors models.Personality, so strict decoding only catches drift between the two
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var personality models.Personality
+	if err := decoder.Decode(&personality); err != nil {
+		return nil, &PersonalityError{Source: source, Problems: []SchemaProblem{{"$", err.Error()}}}
+	}
+	if err := CheckPromptTemplate(&personality); err != nil {
+		return nil, &PersonalityError{Source: source, Problems: []SchemaProblem{{"$.prompt_template", err.Error()}}}
+	}
+	return &personality, nil
+}
+
+// lineAndColumn converts a byte offset into a 1-based line and column
+func lineAndColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// streamIdleTimeout is how long a streamed reply may go without any data from the
+// upstream before the request is abandoned
+var streamIdleTimeout = 60 * time.Second
+
+// errStreamIdle is the cause of streams cancelled by their watchdog
+var errStreamIdle = errors.New("no data from upstream")
+
+// streamWatchdog cancels a streaming request when the upstream stalls. Its deadline
+// covers waiting for the response headers and is renewed by every read of the body
+// that returns data.
+type streamWatchdog struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// newStreamWatchdog derives the context of a streaming request from parent
+func newStreamWatchdog(parent context.Context, timeout time.Duration) *streamWatchdog {
+	ctx, cancel := context.WithCancelCause(parent)
+	return &streamWatchdog{
+		ctx:     ctx,
+		cancel:  cancel,
+		timer:   time.AfterFunc(timeout, func() { cancel(errStreamIdle) }),
+		timeout: timeout,
+	}
+}
+
+// stop releases the watchdog once the stream is finished
+func (w *streamWatchdog) stop() {
+	w.timer.Stop()
+	w.cancel(nil)
+}
+
+// body wraps the body of the watched response so data arriving renews the deadline
+func (w *streamWatchdog) body(body io.Reader) io.Reader {
+	return watchedReader{body: body, watchdog: w}
+}
+
+// err reports a request cancelled by the watchdog as a stalled stream
+func (w *streamWatchdog) err(err error) error {
+	if err != nil && errors.Is(context.Cause(w.ctx), errStreamIdle) {
+		return fmt.Errorf("%w for %v", errStreamIdle, w.timeout)
+	}
+	return err
+}
+
+// watchedReader renews its watchdog's deadline whenever data arrives
+type watchedReader struct {
+	body     io.Reader
+	watchdog *streamWatchdog
+}
+
+func (r watchedReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.watchdog.timer.Reset(r.watchdog.timeout)
+	}
+	return n, err
+}
+
+// readServerSentEvents parses a text/event-stream body and calls onEvent for every
+// complete event. Multi-line data fields are joined with newlines as per the SSE spec.
+func readServerSentEvents(body io.Reader, onEvent func(event, data string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A blank line dispatches the event collected so far
+		if line == "" {
+			if len(data) > 0 {
+				if err := onEvent(event, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, ":"):
+			// Comment line, used by servers as keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Flush a trailing event that was not followed by a blank line
+	if len(data) > 0 {
+		return onEvent(event, strings.Join(data, "\n"))
+	}
+	return nil
+}
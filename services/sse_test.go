@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stallingServer streams one delta and then sends nothing until the test ends
+func stallingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+	return server
+}
+
+func TestStreamAbandonsStalledUpstream(t *testing.T) {
+	previous := streamIdleTimeout
+	streamIdleTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { streamIdleTimeout = previous })
+
+	server := stallingServer(t)
+	provider := NewLocalProvider(server.URL, "", "test")
+
+	var received string
+	start := time.Now()
+	_, err := provider.Stream(context.Background(), CompletionRequest{Messages: []ChatMessage{{Role: "user", Content: "hi"}}}, func(delta string) error {
+		received += delta
+		return nil
+	})
+	if !errors.Is(err, errStreamIdle) {
+		t.Fatalf("Stream error = %v, want %v", err, errStreamIdle)
+	}
+	if received != "Hel" {
+		t.Errorf("received %q before the stall, want %q", received, "Hel")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled stream took %v to give up", elapsed)
+	}
+}
+
+func TestStreamStopsWhenContextIsCancelled(t *testing.T) {
+	server := stallingServer(t)
+	provider := NewLocalProvider(server.URL, "", "test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request := CompletionRequest{Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	_, err := provider.Stream(ctx, request, func(delta string) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Stream error = %v, want %v", err, context.Canceled)
+	}
+}
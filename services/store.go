@@ -0,0 +1,180 @@
+package services
+
+import (
+	"ai-agent-app/models"
+	"errors"
+	"slices"
+	"time"
+)
+
+// ErrNotFound is returned by stores when the requested record does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned by stores when a record with the same key already exists
+var ErrConflict = errors.New("already exists")
+
+// AgentFilter selects the agents returned by AgentStore.List
+type AgentFilter struct {
+	Name            string // Case-insensitive substring of the agent name
+	PersonalityID   string // Only agents bound to this personality
+	IncludeArchived bool
+	Limit           int // Maximum number of agents to return; 0 returns every match
+	Offset          int
+}
+
+// AgentStore persists agents
+type AgentStore interface {
+	// Create saves a new agent and sets its ID and creation time
+	Create(agent *models.Agent) error
+	GetByID(id int) (*models.Agent, error)
+	GetByName(name string) (*models.Agent, error)
+	// List returns a page of the agents matching the filter ordered by ID, together
+	// with the number of matching agents across all pages
+	List(filter AgentFilter) ([]models.Agent, int, error)
+	// Update saves the name, personality binding and archive time of an agent, or
+	// returns ErrNotFound
+	Update(agent *models.Agent) error
+	// Delete removes an agent together with its conversations, messages and summaries
+	Delete(id int) error
+}
+
+// ConversationStore persists conversations
+type ConversationStore interface {
+	Create(conversation *models.Conversation) error
+	GetByID(id int) (*models.Conversation, error)
+	// List returns the conversations of an agent newest first; an empty owner matches all owners
+	List(agentID int, owner string) ([]models.Conversation, error)
+	// Delete removes a conversation together with its messages
+	Delete(id int) error
+}
+
+// HistoryFilter selects a page of messages for MessageStore.Page. Pages are ordered by
+// message ID, which follows the order messages were added in.
+type HistoryFilter struct {
+	Roles   []string  // Only messages with one of these roles; empty matches every role
+	Since   time.Time // Only messages created at or after this time, unless zero
+	Until   time.Time // Only messages created before this time, unless zero
+	AfterID int       // Cursor: with Ascending only IDs above it, otherwise only IDs below it; 0 starts at the end
+	Limit   int       // Maximum number of messages; 0 returns every match
+	// Ascending returns the oldest messages first; otherwise the newest come first
+	Ascending bool
+}
+
+// MessageStore persists chat history messages
+type MessageStore interface {
+	// Add stores a message in the scope and sets its ID
+	Add(scope HistoryScope, msg *Message) error
+	// Recent returns the latest limit messages of the scope in chronological order
+	Recent(scope HistoryScope, limit int) ([]Message, error)
+	// All returns every message of the scope in chronological order
+	All(scope HistoryScope) ([]Message, error)
+	// Since returns the messages of the scope with an ID above afterID in chronological order
+	Since(scope HistoryScope, afterID int) ([]Message, error)
+	// Page returns up to filter.Limit messages of the scope matching the filter, in the
+	// order the filter asks for
+	Page(scope HistoryScope, filter HistoryFilter) ([]Message, error)
+	// SearchText returns up to filter.Limit messages of the scope matching the filter that
+	// contain words of the query, best text rank first
+	SearchText(scope HistoryScope, query string, filter SearchFilter) ([]ScoredMessage, error)
+	// Clear deletes every message of the scope
+	Clear(scope HistoryScope) error
+}
+
+// VectorStore keeps message embeddings and searches them by similarity
+type VectorStore interface {
+	// SetEmbedding stores the embedding of a message; a nil embedding clears it
+	SetEmbedding(messageID int, embedding []float32) error
+	// MissingEmbeddings returns the messages of the backlog without an embedding, in ID order
+	MissingEmbeddings(backlog EmbeddingBacklog) ([]Message, error)
+	// RecordEmbeddingFailure counts a failed embedding attempt for each message
+	RecordEmbeddingFailure(messageIDs []int) error
+	// Search returns up to filter.Limit messages of the scope matching the filter that are
+	// closest to the embedding, most similar first
+	Search(scope HistoryScope, embedding []float32, filter SearchFilter) ([]ScoredMessage, error)
+}
+
+// EmbeddingBacklog selects messages still waiting for an embedding
+type EmbeddingBacklog struct {
+	AfterID     int       // Only messages with a higher ID
+	Before      time.Time // Only messages created before this time, unless zero
+	MaxAttempts int       // Skip messages that failed to embed this many times, unless 0
+	Limit       int
+}
+
+// SearchFilter limits a similarity search to matching messages
+type SearchFilter struct {
+	Roles []string  // Only messages with one of these roles; empty matches every role
+	Since time.Time // Only messages created at or after this time, unless zero
+	Until time.Time // Only messages created before this time, unless zero
+	Limit int
+}
+
+// matches reports whether a message passes the role and time conditions of the filter
+func (f SearchFilter) matches(msg Message) bool {
+	if len(f.Roles) > 0 && !slices.Contains(f.Roles, msg.Role) {
+		return false
+	}
+	if !f.Since.IsZero() && msg.CreatedAt.Before(f.Since) {
+		return false
+	}
+	return f.Until.IsZero() || msg.CreatedAt.Before(f.Until)
+}
+
+// SummaryStore keeps the rolling summary of each agent or conversation history
+type SummaryStore interface {
+	// Get returns the summary of the scope, or ErrNotFound if there is none yet
+	Get(scope HistoryScope) (*Summary, error)
+	// Save creates or replaces the summary of the scope
+	Save(scope HistoryScope, summary *Summary) error
+	// Delete removes the summaries of the scope; an agent scope removes all of the agent's summaries
+	Delete(scope HistoryScope) error
+}
+
+// PersonalityStore persists personalities, keyed by their string ID
+type PersonalityStore interface {
+	// Create saves a new personality, or returns ErrConflict if its ID is taken
+	Create(personality *models.Personality) error
+	GetByID(id string) (*models.Personality, error)
+	// List returns all personalities ordered by ID
+	List() ([]models.Personality, error)
+	// Update replaces a stored personality, or returns ErrNotFound if there is none with its ID
+	Update(personality *models.Personality) error
+	Delete(id string) error
+}
+
+// EmbeddingCacheStore keeps embeddings by model and content hash so identical text is
+// embedded once across instances and restarts
+type EmbeddingCacheStore interface {
+	// Get returns the cached embeddings of the given content hashes; missing hashes are left out
+	Get(model string, hashes []string) (map[string][]float32, error)
+	// Put caches embeddings by content hash
+	Put(model string, embeddings map[string][]float32) error
+}
+
+// ScoredMessage is a search result with its cosine similarity to the query, its full-text
+// rank and, for fused results, its combined score
+type ScoredMessage struct {
+	Message
+	Similarity float32 `json:"similarity"`
+	TextRank   float32 `json:"text_rank"`
+	Score      float64 `json:"score,omitempty"`
+}
+
+// Stores groups the storage backends used by the services
+type Stores struct {
+	Agents        AgentStore
+	Conversations ConversationStore
+	Messages      MessageStore
+	Vectors       VectorStore
+	Summaries     SummaryStore
+	Personalities PersonalityStore
+}
+
+// stores holds the backends in use, Postgres unless UseStores picks others
+var stores = NewPostgresStores()
+
+// UseStores replaces the storage backends used by the services. It must be called
+// before any request is served.
+func UseStores(s Stores) {
+	stores = s
+}
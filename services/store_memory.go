@@ -0,0 +1,571 @@
+package services
+
+import (
+	"ai-agent-app/models"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryData is the shared state behind the in-memory stores. A single lock keeps
+// cross-store operations such as deleting a conversation with its messages consistent.
+type memoryData struct {
+	mu                 sync.RWMutex
+	agents             []models.Agent
+	conversations      []models.Conversation
+	messages           []memoryMessage
+	summaries          map[HistoryScope]Summary
+	personalities      map[string]models.Personality
+	nextAgentID        int
+	nextConversationID int
+	nextMessageID      int
+}
+
+// memoryMessage is a stored message together with the scope it belongs to
+type memoryMessage struct {
+	Message
+	agentID           int
+	conversationID    int
+	embeddingAttempts int
+}
+
+// inScope reports whether the message belongs to the scope
+func (m memoryMessage) inScope(scope HistoryScope) bool {
+	if m.agentID != scope.AgentID {
+		return false
+	}
+	return scope.ConversationID == 0 || m.conversationID == scope.ConversationID
+}
+
+// NewMemoryStores returns stores that keep everything in process memory. Nothing is
+// persisted, which makes them suitable for tests and lightweight local runs.
+func NewMemoryStores() Stores {
+	data := &memoryData{
+		summaries:     make(map[HistoryScope]Summary),
+		personalities: make(map[string]models.Personality),
+	}
+	return Stores{
+		Agents:        memoryAgentStore{data},
+		Conversations: memoryConversationStore{data},
+		Messages:      memoryMessageStore{data},
+		Vectors:       memoryVectorStore{data},
+		Summaries:     memorySummaryStore{data},
+		Personalities: memoryPersonalityStore{data},
+	}
+}
+
+// memoryAgentStore implements AgentStore in memory
+type memoryAgentStore struct {
+	data *memoryData
+}
+
+// Create saves a new agent and sets its ID
+func (s memoryAgentStore) Create(agent *models.Agent) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	s.data.nextAgentID++
+	agent.ID = s.data.nextAgentID
+	agent.CreatedAt = time.Now()
+	s.data.agents = append(s.data.agents, *agent)
+	return nil
+}
+
+// GetByID retrieves an agent by its ID
+func (s memoryAgentStore) GetByID(id int) (*models.Agent, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	for _, agent := range s.data.agents {
+		if agent.ID == id {
+			return &agent, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetByName retrieves an agent by its name
+func (s memoryAgentStore) GetByName(name string) (*models.Agent, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	for _, agent := range s.data.agents {
+		if agent.Name == name {
+			return &agent, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// List returns a page of the agents matching the filter ordered by ID
+func (s memoryAgentStore) List(filter AgentFilter) ([]models.Agent, int, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	name := strings.ToLower(filter.Name)
+	matches := []models.Agent{}
+	for _, agent := range s.data.agents {
+		if name != "" && !strings.Contains(strings.ToLower(agent.Name), name) {
+			continue
+		}
+		if filter.PersonalityID != "" && agent.PersonalityID != filter.PersonalityID {
+			continue
+		}
+		if !filter.IncludeArchived && agent.ArchivedAt != nil {
+			continue
+		}
+		matches = append(matches, agent)
+	}
+
+	total := len(matches)
+	start := min(filter.Offset, total)
+	end := total
+	if filter.Limit > 0 {
+		end = min(start+filter.Limit, total)
+	}
+	return append([]models.Agent{}, matches[start:end]...), total, nil
+}
+
+// Update saves the name, personality binding and archive time of an agent
+func (s memoryAgentStore) Update(agent *models.Agent) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for i := range s.data.agents {
+		if s.data.agents[i].ID == agent.ID {
+			s.data.agents[i] = *agent
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Delete removes an agent together with its conversations, messages and summaries
+func (s memoryAgentStore) Delete(id int) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for i, agent := range s.data.agents {
+		if agent.ID != id {
+			continue
+		}
+		s.data.agents = append(s.data.agents[:i], s.data.agents[i+1:]...)
+
+		conversations := s.data.conversations[:0]
+		for _, conversation := range s.data.conversations {
+			if conversation.AgentID != id {
+				conversations = append(conversations, conversation)
+			}
+		}
+		s.data.conversations = conversations
+
+		messages := s.data.messages[:0]
+		for _, msg := range s.data.messages {
+			if msg.agentID != id {
+				messages = append(messages, msg)
+			}
+		}
+		s.data.messages = messages
+
+		for scope := range s.data.summaries {
+			if scope.AgentID == id {
+				delete(s.data.summaries, scope)
+			}
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+// memoryConversationStore implements ConversationStore in memory
+type memoryConversationStore struct {
+	data *memoryData
+}
+
+// Create saves a new conversation and sets its ID and creation time
+func (s memoryConversationStore) Create(conversation *models.Conversation) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	s.data.nextConversationID++
+	conversation.ID = s.data.nextConversationID
+	conversation.CreatedAt = time.Now()
+	s.data.conversations = append(s.data.conversations, *conversation)
+	return nil
+}
+
+// GetByID retrieves a conversation by its ID
+func (s memoryConversationStore) GetByID(id int) (*models.Conversation, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	for _, conversation := range s.data.conversations {
+		if conversation.ID == id {
+			return &conversation, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// List returns the conversations of an agent, newest first
+func (s memoryConversationStore) List(agentID int, owner string) ([]models.Conversation, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	conversations := []models.Conversation{}
+	for i := len(s.data.conversations) - 1; i >= 0; i-- {
+		conversation := s.data.conversations[i]
+		if conversation.AgentID == agentID && (owner == "" || conversation.Owner == owner) {
+			conversations = append(conversations, conversation)
+		}
+	}
+	return conversations, nil
+}
+
+// Delete removes a conversation together with its messages
+func (s memoryConversationStore) Delete(id int) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for i, conversation := range s.data.conversations {
+		if conversation.ID != id {
+			continue
+		}
+		s.data.conversations = append(s.data.conversations[:i], s.data.conversations[i+1:]...)
+
+		kept := s.data.messages[:0]
+		for _, msg := range s.data.messages {
+			if msg.conversationID != id {
+				kept = append(kept, msg)
+			}
+		}
+		s.data.messages = kept
+		delete(s.data.summaries, ConversationScope(conversation.AgentID, id))
+		return nil
+	}
+	return ErrNotFound
+}
+
+// memoryMessageStore implements MessageStore in memory
+type memoryMessageStore struct {
+	data *memoryData
+}
+
+// Add stores a message and sets its ID
+func (s memoryMessageStore) Add(scope HistoryScope, msg *Message) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	s.data.nextMessageID++
+	msg.ID = s.data.nextMessageID
+	msg.CreatedAt = time.Now()
+	s.data.messages = append(s.data.messages, memoryMessage{
+		Message:        *msg,
+		agentID:        scope.AgentID,
+		conversationID: scope.ConversationID,
+	})
+	return nil
+}
+
+// Recent returns the latest limit messages of the scope, oldest first
+func (s memoryMessageStore) Recent(scope HistoryScope, limit int) ([]Message, error) {
+	messages, _ := s.All(scope)
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages, nil
+}
+
+// All returns every message of the scope, oldest first
+func (s memoryMessageStore) All(scope HistoryScope) ([]Message, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	var messages []Message
+	for _, msg := range s.data.messages {
+		if msg.inScope(scope) {
+			messages = append(messages, msg.Message)
+		}
+	}
+	return messages, nil
+}
+
+// Since returns the messages of the scope newer than afterID, oldest first
+func (s memoryMessageStore) Since(scope HistoryScope, afterID int) ([]Message, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	var messages []Message
+	for _, msg := range s.data.messages {
+		if msg.inScope(scope) && msg.ID > afterID {
+			messages = append(messages, msg.Message)
+		}
+	}
+	return messages, nil
+}
+
+// Page returns a page of the scope's messages matching the filter
+func (s memoryMessageStore) Page(scope HistoryScope, filter HistoryFilter) ([]Message, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	matches := func(msg memoryMessage) bool {
+		if !msg.inScope(scope) {
+			return false
+		}
+		if filter.AfterID != 0 && (filter.Ascending && msg.ID <= filter.AfterID || !filter.Ascending && msg.ID >= filter.AfterID) {
+			return false
+		}
+		return SearchFilter{Roles: filter.Roles, Since: filter.Since, Until: filter.Until}.matches(msg.Message)
+	}
+
+	// Messages are kept in ID order, so walk them from the requested end
+	messages := []Message{}
+	for i := range s.data.messages {
+		if filter.Limit > 0 && len(messages) == filter.Limit {
+			break
+		}
+		msg := s.data.messages[i]
+		if !filter.Ascending {
+			msg = s.data.messages[len(s.data.messages)-1-i]
+		}
+		if matches(msg) {
+			messages = append(messages, msg.Message)
+		}
+	}
+	return messages, nil
+}
+
+// SearchText ranks the scope's messages by the words they share with the query
+func (s memoryMessageStore) SearchText(scope HistoryScope, query string, filter SearchFilter) ([]ScoredMessage, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	var candidates []Message
+	var documents []string
+	for _, msg := range s.data.messages {
+		if msg.inScope(scope) && filter.matches(msg.Message) {
+			candidates = append(candidates, msg.Message)
+			documents = append(documents, msg.Content)
+		}
+	}
+
+	var results []ScoredMessage
+	for i, rank := range rankText(query, documents) {
+		if rank > 0 {
+			results = append(results, ScoredMessage{Message: candidates[i], TextRank: rank})
+		}
+	}
+	// Equal ranks put the newest message first, as the database does
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].TextRank != results[j].TextRank {
+			return results[i].TextRank > results[j].TextRank
+		}
+		return results[i].ID > results[j].ID
+	})
+	if len(results) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+	return results, nil
+}
+
+// Clear deletes every message of the scope
+func (s memoryMessageStore) Clear(scope HistoryScope) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	kept := s.data.messages[:0]
+	for _, msg := range s.data.messages {
+		if !msg.inScope(scope) {
+			kept = append(kept, msg)
+		}
+	}
+	s.data.messages = kept
+	return nil
+}
+
+// memoryVectorStore implements VectorStore with a brute-force cosine similarity scan
+type memoryVectorStore struct {
+	data *memoryData
+}
+
+// SetEmbedding stores the embedding of a message
+func (s memoryVectorStore) SetEmbedding(messageID int, embedding []float32) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for i := range s.data.messages {
+		if s.data.messages[i].ID == messageID {
+			s.data.messages[i].Embedding = embedding
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// MissingEmbeddings returns the messages of the backlog without an embedding
+func (s memoryVectorStore) MissingEmbeddings(backlog EmbeddingBacklog) ([]Message, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	messages := []Message{}
+	for _, msg := range s.data.messages {
+		if len(messages) == backlog.Limit {
+			break
+		}
+		if msg.ID <= backlog.AfterID || len(msg.Embedding) > 0 || strings.TrimSpace(msg.Content) == "" {
+			continue
+		}
+		if !backlog.Before.IsZero() && !msg.CreatedAt.Before(backlog.Before) {
+			continue
+		}
+		if backlog.MaxAttempts > 0 && msg.embeddingAttempts >= backlog.MaxAttempts {
+			continue
+		}
+		messages = append(messages, msg.Message)
+	}
+	return messages, nil
+}
+
+// RecordEmbeddingFailure counts a failed embedding attempt for each message
+func (s memoryVectorStore) RecordEmbeddingFailure(messageIDs []int) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for i := range s.data.messages {
+		if slices.Contains(messageIDs, s.data.messages[i].ID) {
+			s.data.messages[i].embeddingAttempts++
+		}
+	}
+	return nil
+}
+
+// Search compares the embedding with every embedded message of the scope
+func (s memoryVectorStore) Search(scope HistoryScope, embedding []float32, filter SearchFilter) ([]ScoredMessage, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	var candidates []Message
+	var vectors [][]float32
+	for _, msg := range s.data.messages {
+		if !msg.inScope(scope) || len(msg.Embedding) == 0 || !filter.matches(msg.Message) {
+			continue
+		}
+		candidates = append(candidates, msg.Message)
+		vectors = append(vectors, msg.Embedding)
+	}
+
+	var results []ScoredMessage
+	for _, match := range TopK(embedding, vectors, filter.Limit) {
+		results = append(results, ScoredMessage{Message: candidates[match.Index], Similarity: match.Score})
+	}
+	return results, nil
+}
+
+// memorySummaryStore implements SummaryStore in memory
+type memorySummaryStore struct {
+	data *memoryData
+}
+
+// Get returns the summary of the scope
+func (s memorySummaryStore) Get(scope HistoryScope) (*Summary, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	summary, ok := s.data.summaries[scope]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &summary, nil
+}
+
+// Save creates or replaces the summary of the scope
+func (s memorySummaryStore) Save(scope HistoryScope, summary *Summary) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	summary.UpdatedAt = time.Now()
+	s.data.summaries[scope] = *summary
+	return nil
+}
+
+// Delete removes the summaries of the scope
+func (s memorySummaryStore) Delete(scope HistoryScope) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for key := range s.data.summaries {
+		if key.AgentID == scope.AgentID && (scope.ConversationID == 0 || key.ConversationID == scope.ConversationID) {
+			delete(s.data.summaries, key)
+		}
+	}
+	return nil
+}
+
+// memoryPersonalityStore implements PersonalityStore in memory
+type memoryPersonalityStore struct {
+	data *memoryData
+}
+
+// Create saves a new personality
+func (s memoryPersonalityStore) Create(personality *models.Personality) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if _, ok := s.data.personalities[personality.ID]; ok {
+		return ErrConflict
+	}
+	s.data.personalities[personality.ID] = *personality
+	return nil
+}
+
+// GetByID retrieves a personality by its ID
+func (s memoryPersonalityStore) GetByID(id string) (*models.Personality, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	personality, ok := s.data.personalities[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &personality, nil
+}
+
+// List returns all personalities ordered by ID
+func (s memoryPersonalityStore) List() ([]models.Personality, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	personalities := make([]models.Personality, 0, len(s.data.personalities))
+	for _, personality := range s.data.personalities {
+		personalities = append(personalities, personality)
+	}
+	sort.Slice(personalities, func(i, j int) bool { return personalities[i].ID < personalities[j].ID })
+	return personalities, nil
+}
+
+// Update replaces a stored personality
+func (s memoryPersonalityStore) Update(personality *models.Personality) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if _, ok := s.data.personalities[personality.ID]; !ok {
+		return ErrNotFound
+	}
+	s.data.personalities[personality.ID] = *personality
+	return nil
+}
+
+// Delete removes a personality
+func (s memoryPersonalityStore) Delete(id string) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if _, ok := s.data.personalities[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.data.personalities, id)
+	return nil
+}
@@ -0,0 +1,882 @@
+package services
+
+import (
+	"ai-agent-app/database"
+	"ai-agent-app/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// NewPostgresStores returns stores backed by the Postgres database from the database package
+func NewPostgresStores() Stores {
+	return Stores{
+		Agents:        postgresAgentStore{},
+		Conversations: postgresConversationStore{},
+		Messages:      postgresMessageStore{},
+		Vectors:       postgresVectorStore{},
+		Summaries:     postgresSummaryStore{},
+		Personalities: postgresPersonalityStore{},
+	}
+}
+
+// notFound translates sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// condition returns the SQL condition matching the scope's messages, numbering
+// its placeholders from $next, together with the arguments they bind
+func (s HistoryScope) condition(next int) (string, []interface{}) {
+	if s.ConversationID == 0 {
+		return fmt.Sprintf("agent_id = $%d", next), []interface{}{s.AgentID}
+	}
+	return fmt.Sprintf("agent_id = $%d AND conversation_id = $%d", next, next+1), []interface{}{s.AgentID, s.ConversationID}
+}
+
+// postgresAgentStore implements AgentStore on the agents table
+type postgresAgentStore struct{}
+
+// agentColumns lists the columns read by agent queries, in scanAgent order
+const agentColumns = `id, name, COALESCE(personality_id, ''), created_at, archived_at`
+
+// scanAgent reads an agent row selected with agentColumns
+func scanAgent(row interface{ Scan(...interface{}) error }) (*models.Agent, error) {
+	var agent models.Agent
+	var archivedAt sql.NullTime
+	if err := row.Scan(&agent.ID, &agent.Name, &agent.PersonalityID, &agent.CreatedAt, &archivedAt); err != nil {
+		return nil, err
+	}
+	if archivedAt.Valid {
+		agent.ArchivedAt = &archivedAt.Time
+	}
+	return &agent, nil
+}
+
+// Create saves a new agent and sets its ID and creation time
+func (postgresAgentStore) Create(agent *models.Agent) error {
+	// Prepare the SQL statement with RETURNING clause to get the generated ID
+	query := `INSERT INTO agents (name, personality_id) VALUES ($1, NULLIF($2, '')) RETURNING id, created_at`
+	err := database.GetDB().QueryRow(query,
+		agent.Name,
+		agent.PersonalityID,
+	).Scan(&agent.ID, &agent.CreatedAt)
+
+	if err != nil {
+		log.Printf("Error saving agent to database: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetByID retrieves an agent by its ID
+func (postgresAgentStore) GetByID(id int) (*models.Agent, error) {
+	query := `SELECT ` + agentColumns + ` FROM agents WHERE id = $1`
+
+	agent, err := scanAgent(database.GetDB().QueryRow(query, id))
+	if err != nil {
+		log.Printf("Error retrieving agent with ID %d: %v", id, err)
+		return nil, notFound(err)
+	}
+
+	return agent, nil
+}
+
+// GetByName retrieves an agent by its name
+func (postgresAgentStore) GetByName(name string) (*models.Agent, error) {
+	query := `SELECT ` + agentColumns + ` FROM agents WHERE name = $1 ORDER BY id LIMIT 1`
+
+	agent, err := scanAgent(database.GetDB().QueryRow(query, name))
+	if err != nil {
+		log.Printf("Error retrieving agent with name %s: %v", name, err)
+		return nil, notFound(err)
+	}
+
+	return agent, nil
+}
+
+// List returns a page of the agents matching the filter ordered by ID
+func (postgresAgentStore) List(filter AgentFilter) ([]models.Agent, int, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Name != "" {
+		args = append(args, "%"+escapeLike(filter.Name)+"%")
+		conditions = append(conditions, fmt.Sprintf("name ILIKE $%d", len(args)))
+	}
+	if filter.PersonalityID != "" {
+		args = append(args, filter.PersonalityID)
+		conditions = append(conditions, fmt.Sprintf("personality_id = $%d", len(args)))
+	}
+	if !filter.IncludeArchived {
+		conditions = append(conditions, "archived_at IS NULL")
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	db := database.GetDB()
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM agents`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting agents: %w", err)
+	}
+
+	// A NULL limit returns every row
+	var limit interface{}
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	args = append(args, limit, filter.Offset)
+	query := fmt.Sprintf(`SELECT %s FROM agents%s ORDER BY id LIMIT $%d OFFSET $%d`, agentColumns, where, len(args)-1, len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying agents: %w", err)
+	}
+	defer rows.Close()
+
+	agents := []models.Agent{}
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning agent row: %w", err)
+		}
+		agents = append(agents, *agent)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating agent rows: %w", err)
+	}
+
+	return agents, total, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// Update saves the name, personality binding and archive time of an agent
+func (postgresAgentStore) Update(agent *models.Agent) error {
+	query := `UPDATE agents SET name = $2, personality_id = NULLIF($3, ''), archived_at = $4 WHERE id = $1`
+	result, err := database.Exec(query, agent.ID, agent.Name, agent.PersonalityID, agent.ArchivedAt)
+	if err != nil {
+		return fmt.Errorf("error updating agent %d: %w", agent.ID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes an agent together with its conversations, messages and summaries
+// in one transaction
+func (postgresAgentStore) Delete(id int) error {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Children first, since their foreign keys to agents do not cascade
+	for _, table := range []string{"conversation_summaries", "chat_history", "conversations"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE agent_id = $1`, id); err != nil {
+			return fmt.Errorf("error deleting %s of agent %d: %w", table, id, err)
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM agents WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting agent %d: %w", id, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+// postgresConversationStore implements ConversationStore on the conversations table
+type postgresConversationStore struct{}
+
+// Create saves a new conversation and sets its ID and creation time
+func (postgresConversationStore) Create(conversation *models.Conversation) error {
+	query := `
+		INSERT INTO conversations (agent_id, owner, title)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+	err := database.GetDB().QueryRow(query,
+		conversation.AgentID,
+		conversation.Owner,
+		conversation.Title,
+	).Scan(&conversation.ID, &conversation.CreatedAt)
+
+	if err != nil {
+		log.Printf("Error saving conversation to database: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetByID retrieves a conversation by its ID
+func (postgresConversationStore) GetByID(id int) (*models.Conversation, error) {
+	query := `SELECT id, agent_id, owner, title, created_at FROM conversations WHERE id = $1`
+
+	var conversation models.Conversation
+	err := database.GetDB().QueryRow(query, id).Scan(
+		&conversation.ID,
+		&conversation.AgentID,
+		&conversation.Owner,
+		&conversation.Title,
+		&conversation.CreatedAt,
+	)
+
+	if err != nil {
+		log.Printf("Error retrieving conversation with ID %d: %v", id, err)
+		return nil, notFound(err)
+	}
+
+	return &conversation, nil
+}
+
+// List returns the conversations of an agent, newest first
+func (postgresConversationStore) List(agentID int, owner string) ([]models.Conversation, error) {
+	query := `
+		SELECT id, agent_id, owner, title, created_at
+		FROM conversations
+		WHERE agent_id = $1 AND ($2 = '' OR owner = $2)
+		ORDER BY created_at DESC, id DESC`
+
+	rows, err := database.GetDB().Query(query, agentID, owner)
+	if err != nil {
+		return nil, fmt.Errorf("error querying conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []models.Conversation{}
+	for rows.Next() {
+		var conversation models.Conversation
+		if err := rows.Scan(&conversation.ID, &conversation.AgentID, &conversation.Owner, &conversation.Title, &conversation.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning conversation row: %w", err)
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating conversation rows: %w", err)
+	}
+
+	return conversations, nil
+}
+
+// Delete removes a conversation; its messages go with it through ON DELETE CASCADE
+func (postgresConversationStore) Delete(id int) error {
+	result, err := database.Exec(`DELETE FROM conversations WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting conversation: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// postgresMessageStore implements MessageStore on the chat_history table
+type postgresMessageStore struct{}
+
+// Add inserts a message and sets its ID
+func (postgresMessageStore) Add(scope HistoryScope, msg *Message) error {
+	query := `
+		INSERT INTO chat_history (agent_id, conversation_id, role, content, tool_call_id, tool_name)
+		VALUES ($1, NULLIF($2, 0), $3, $4, NULLIF($5, ''), NULLIF($6, ''))
+		RETURNING id, created_at`
+
+	err := database.GetDB().QueryRow(query, scope.AgentID, scope.ConversationID, msg.Role, msg.Content, msg.ToolCallID, msg.ToolName).Scan(&msg.ID, &msg.CreatedAt)
+	if err != nil {
+		log.Printf("Error adding message to chat history: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Recent returns the latest limit messages of the scope, oldest first
+func (postgresMessageStore) Recent(scope HistoryScope, limit int) ([]Message, error) {
+	condition, args := scope.condition(2)
+	query := `
+		SELECT ` + messageColumns + `
+		FROM chat_history
+		WHERE ` + condition + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1`
+
+	messages, err := queryMessages(query, append([]interface{}{limit}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reverse the messages to get chronological order (oldest first)
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// All returns every message of the scope, oldest first
+func (postgresMessageStore) All(scope HistoryScope) ([]Message, error) {
+	condition, args := scope.condition(1)
+	query := `
+		SELECT ` + messageColumns + `
+		FROM chat_history
+		WHERE ` + condition + `
+		ORDER BY created_at ASC, id ASC`
+
+	return queryMessages(query, args...)
+}
+
+// Since returns the messages of the scope newer than afterID, oldest first
+func (postgresMessageStore) Since(scope HistoryScope, afterID int) ([]Message, error) {
+	condition, args := scope.condition(2)
+	query := `
+		SELECT ` + messageColumns + `
+		FROM chat_history
+		WHERE ` + condition + ` AND id > $1
+		ORDER BY created_at ASC, id ASC`
+
+	return queryMessages(query, append([]interface{}{afterID}, args...)...)
+}
+
+// Page returns a page of the scope's messages matching the filter
+func (postgresMessageStore) Page(scope HistoryScope, filter HistoryFilter) ([]Message, error) {
+	condition, args := scope.condition(1)
+	conditions := []string{condition}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+	if filter.AfterID != 0 {
+		if filter.Ascending {
+			addCondition("id > $%d", filter.AfterID)
+		} else {
+			addCondition("id < $%d", filter.AfterID)
+		}
+	}
+	conditions, args = SearchFilter{Roles: filter.Roles, Since: filter.Since, Until: filter.Until}.conditions(conditions, args)
+	// LIMIT NULL returns every match
+	var limit interface{}
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM chat_history
+		WHERE %s
+		ORDER BY id %s
+		LIMIT $%d`, messageColumns, strings.Join(conditions, " AND "), order, len(args))
+
+	messages, err := queryMessages(query, args...)
+	if messages == nil {
+		messages = []Message{}
+	}
+	return messages, err
+}
+
+// SearchText ranks the scope's messages by full-text search on the content_tsv column.
+// Any word of the query may match; messages with more and rarer matches rank higher.
+func (postgresMessageStore) SearchText(scope HistoryScope, query string, filter SearchFilter) ([]ScoredMessage, error) {
+	tsquery := textQuery(query)
+	if tsquery == "" {
+		return []ScoredMessage{}, nil
+	}
+
+	where, args := searchConditions(scope, filter, 3, "content_tsv @@ query")
+	sqlQuery := `
+		SELECT ` + messageColumns + `, ts_rank_cd(content_tsv, query) AS rank
+		FROM chat_history, to_tsquery('english', $1) query
+		WHERE ` + where + `
+		ORDER BY rank DESC, id DESC
+		LIMIT $2`
+
+	rows, err := database.GetDB().Query(sqlQuery, append([]interface{}{tsquery, filter.Limit}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching chat history text: %w", err)
+	}
+	defer rows.Close()
+
+	results := []ScoredMessage{}
+	for rows.Next() {
+		var result ScoredMessage
+		if err := rows.Scan(&result.ID, &result.Role, &result.Content, &result.ToolCallID, &result.ToolName, &result.CreatedAt, &result.TextRank); err != nil {
+			log.Printf("Error scanning text search result: %v", err)
+			continue
+		}
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating text search results: %w", err)
+	}
+
+	return results, nil
+}
+
+// Clear deletes every message of the scope
+func (postgresMessageStore) Clear(scope HistoryScope) error {
+	condition, args := scope.condition(1)
+	query := `DELETE FROM chat_history WHERE ` + condition
+	_, err := database.Exec(query, args...)
+	return err
+}
+
+// conditions appends the SQL conditions of the filter's roles and time range to a WHERE
+// clause whose placeholders are numbered after args
+func (f SearchFilter) conditions(conditions []string, args []interface{}) ([]string, []interface{}) {
+	add := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+	if len(f.Roles) > 0 {
+		add("role = ANY($%d)", pq.Array(f.Roles))
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+	return conditions, args
+}
+
+// messageColumns lists the columns read by message queries, in queryMessages scan order
+const messageColumns = `id, role, content, COALESCE(tool_call_id, ''), COALESCE(tool_name, ''), COALESCE(created_at, to_timestamp(0))`
+
+// queryMessages runs a query selecting messageColumns
+func queryMessages(query string, args ...interface{}) ([]Message, error) {
+	db := database.GetDB()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying chat history: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content, &msg.ToolCallID, &msg.ToolName, &msg.CreatedAt); err != nil {
+			log.Printf("Error scanning chat history row: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, fmt.Errorf("error iterating chat history rows: %w", err)
+	}
+
+	return messages, nil
+}
+
+// postgresVectorStore implements VectorStore on the pgvector embedding column of chat_history
+type postgresVectorStore struct{}
+
+// SetEmbedding stores the embedding of a message; a nil embedding clears it
+func (postgresVectorStore) SetEmbedding(messageID int, embedding []float32) error {
+	_, err := database.Exec(`UPDATE chat_history SET embedding = $1 WHERE id = $2`, database.Vector(embedding), messageID)
+	if err != nil {
+		return fmt.Errorf("error storing embedding: %w", err)
+	}
+	return nil
+}
+
+// MissingEmbeddings returns the messages of the backlog without an embedding, in ID order
+func (postgresVectorStore) MissingEmbeddings(backlog EmbeddingBacklog) ([]Message, error) {
+	conditions := []string{"embedding IS NULL", "id > $1", "btrim(content) <> ''"}
+	args := []interface{}{backlog.AfterID, backlog.Limit}
+	if !backlog.Before.IsZero() {
+		args = append(args, backlog.Before)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if backlog.MaxAttempts > 0 {
+		args = append(args, backlog.MaxAttempts)
+		conditions = append(conditions, fmt.Sprintf("embedding_attempts < $%d", len(args)))
+	}
+
+	query := `
+		SELECT ` + messageColumns + `
+		FROM chat_history
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY id
+		LIMIT $2`
+	return queryMessages(query, args...)
+}
+
+// RecordEmbeddingFailure counts a failed embedding attempt for each message
+func (postgresVectorStore) RecordEmbeddingFailure(messageIDs []int) error {
+	_, err := database.Exec(`UPDATE chat_history SET embedding_attempts = embedding_attempts + 1 WHERE id = ANY($1)`, pq.Array(messageIDs))
+	if err != nil {
+		return fmt.Errorf("error recording embedding failure: %w", err)
+	}
+	return nil
+}
+
+// Search finds the messages of the scope closest to the embedding by cosine distance.
+// Scopes with few embedded messages are scanned exactly through the agent index; larger
+// ones go through the approximate nearest neighbour index, tuned for this query only.
+func (postgresVectorStore) Search(scope HistoryScope, embedding []float32, filter SearchFilter) ([]ScoredMessage, error) {
+	// Search settings are applied with SET LOCAL, so they only last for this transaction
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting vector search: %w", err)
+	}
+	defer tx.Rollback()
+
+	config := database.GetVectorIndexConfig()
+	exact := config.Method == database.VectorIndexNone
+	if !exact && config.ExactSearchThreshold > 0 {
+		where, args := searchConditions(scope, filter, 2, "embedding IS NOT NULL")
+		var embedded int
+		countQuery := `SELECT COUNT(*) FROM (SELECT 1 FROM chat_history WHERE ` + where + ` LIMIT $1) candidates`
+		if err := tx.QueryRow(countQuery, append([]interface{}{config.ExactSearchThreshold + 1}, args...)...).Scan(&embedded); err != nil {
+			return nil, fmt.Errorf("error counting embedded messages: %w", err)
+		}
+		exact = embedded <= config.ExactSearchThreshold
+	}
+
+	// Search for similar messages using cosine distance
+	where, args := searchConditions(scope, filter, 3, "embedding IS NOT NULL")
+	var sqlQuery string
+	if exact {
+		// Materializing the scope first keeps the planner from walking the whole index
+		// and discarding other agents' messages
+		sqlQuery = `
+		WITH candidates AS MATERIALIZED (
+			SELECT * FROM chat_history WHERE ` + where + `
+		)
+		SELECT ` + messageColumns + `, embedding, embedding <=> $1 AS distance
+		FROM candidates
+		ORDER BY distance ASC
+		LIMIT $2`
+	} else {
+		for _, setting := range config.SearchSettings() {
+			if _, err := tx.Exec(setting); err != nil {
+				return nil, fmt.Errorf("error tuning vector search: %w", err)
+			}
+		}
+		sqlQuery = `
+		SELECT ` + messageColumns + `, embedding, embedding <=> $1 AS distance
+		FROM chat_history
+		WHERE ` + where + `
+		ORDER BY distance ASC
+		LIMIT $2`
+	}
+
+	rows, err := tx.Query(sqlQuery, append([]interface{}{database.Vector(embedding), filter.Limit}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching similar messages: %v", err)
+	}
+	defer rows.Close()
+
+	var results []ScoredMessage
+	for rows.Next() {
+		var result ScoredMessage
+		var distance float32
+		var embedding database.Vector
+
+		if err := rows.Scan(&result.ID, &result.Role, &result.Content, &result.ToolCallID, &result.ToolName, &result.CreatedAt, &embedding, &distance); err != nil {
+			log.Printf("Error scanning search result: %v", err)
+			continue
+		}
+		result.Similarity = 1 - distance
+		result.Embedding = embedding
+
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search results: %v", err)
+	}
+
+	return results, tx.Commit()
+}
+
+// searchConditions returns the WHERE clause selecting the messages of the scope that match
+// the filter and the extra conditions, with placeholders numbered from next
+func searchConditions(scope HistoryScope, filter SearchFilter, next int, extra ...string) (string, []interface{}) {
+	condition, args := scope.condition(next)
+	// filter.conditions numbers its placeholders after args, so pad for the leading ones
+	padding := make([]interface{}, next-1)
+	conditions, args := filter.conditions(append([]string{condition}, extra...), append(padding, args...))
+	return strings.Join(conditions, " AND "), args[next-1:]
+}
+
+// postgresEmbeddingCacheStore implements EmbeddingCacheStore on the embedding_cache table
+type postgresEmbeddingCacheStore struct{}
+
+// NewPostgresEmbeddingCacheStore returns an embedding cache kept in the embedding_cache table
+func NewPostgresEmbeddingCacheStore() EmbeddingCacheStore {
+	return postgresEmbeddingCacheStore{}
+}
+
+// Get returns the cached embeddings of the given content hashes
+func (postgresEmbeddingCacheStore) Get(model string, hashes []string) (map[string][]float32, error) {
+	query := `
+		SELECT content_hash, embedding
+		FROM embedding_cache
+		WHERE model = $1 AND content_hash = ANY($2)`
+
+	rows, err := database.GetDB().Query(query, model, pq.Array(hashes))
+	if err != nil {
+		return nil, fmt.Errorf("error querying embedding cache: %w", err)
+	}
+	defer rows.Close()
+
+	embeddings := make(map[string][]float32)
+	for rows.Next() {
+		var hash string
+		var embedding database.Vector
+		if err := rows.Scan(&hash, &embedding); err != nil {
+			return nil, fmt.Errorf("error scanning embedding cache row: %w", err)
+		}
+		embeddings[hash] = embedding
+	}
+
+	return embeddings, rows.Err()
+}
+
+// Put caches embeddings by content hash, keeping existing entries
+func (postgresEmbeddingCacheStore) Put(model string, embeddings map[string][]float32) error {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return fmt.Errorf("error starting embedding cache write: %w", err)
+	}
+	defer tx.Rollback()
+
+	for hash, embedding := range embeddings {
+		_, err := tx.Exec(`
+			INSERT INTO embedding_cache (model, content_hash, embedding)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (model, content_hash) DO NOTHING`, model, hash, database.Vector(embedding))
+		if err != nil {
+			return fmt.Errorf("error writing embedding cache: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// postgresSummaryStore implements SummaryStore on the conversation_summaries table
+type postgresSummaryStore struct{}
+
+// Get returns the summary of the scope
+func (postgresSummaryStore) Get(scope HistoryScope) (*Summary, error) {
+	query := `
+		SELECT summary, covered_through_id, updated_at
+		FROM conversation_summaries
+		WHERE agent_id = $1 AND COALESCE(conversation_id, 0) = $2`
+
+	var summary Summary
+	err := database.GetDB().QueryRow(query, scope.AgentID, scope.ConversationID).Scan(
+		&summary.Content,
+		&summary.CoveredThroughID,
+		&summary.UpdatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &summary, nil
+}
+
+// Save creates or replaces the summary of the scope
+func (postgresSummaryStore) Save(scope HistoryScope, summary *Summary) error {
+	query := `
+		INSERT INTO conversation_summaries (agent_id, conversation_id, summary, covered_through_id)
+		VALUES ($1, NULLIF($2, 0), $3, $4)
+		ON CONFLICT (agent_id, (COALESCE(conversation_id, 0))) DO UPDATE
+		SET summary = EXCLUDED.summary,
+			covered_through_id = EXCLUDED.covered_through_id,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at`
+
+	err := database.GetDB().QueryRow(query, scope.AgentID, scope.ConversationID, summary.Content, summary.CoveredThroughID).Scan(&summary.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error saving summary: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the summaries of the scope
+func (postgresSummaryStore) Delete(scope HistoryScope) error {
+	condition, args := scope.condition(1)
+	_, err := database.Exec(`DELETE FROM conversation_summaries WHERE `+condition, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting summary: %w", err)
+	}
+	return nil
+}
+
+// postgresPersonalityStore implements PersonalityStore on the personalities table
+type postgresPersonalityStore struct{}
+
+// personalityColumns lists the columns read by personality queries, in scan order
+const personalityColumns = `id, name, provider, description, system, bio, lore, knowledge, style, adjectives, instructions, tools, prompt_template, model_params`
+
+// personalityArgs returns the column values of a personality in personalityColumns order
+func personalityArgs(p *models.Personality) ([]interface{}, error) {
+	args := []interface{}{p.ID, p.Name, p.Provider, p.Description, p.System}
+	for _, value := range []interface{}{jsonList(p.Bio), jsonList(p.Lore), jsonList(p.Knowledge), p.Style, jsonList(p.Adjectives)} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding personality %s: %w", p.ID, err)
+		}
+		args = append(args, data)
+	}
+	tools, err := json.Marshal(jsonList(p.Tools))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding personality %s: %w", p.ID, err)
+	}
+	params, err := json.Marshal(p.ModelParams)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding personality %s: %w", p.ID, err)
+	}
+	return append(args, p.Instructions, tools, p.PromptTemplate, params), nil
+}
+
+// jsonList makes nil lists encode as [] rather than null
+func jsonList(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// scanPersonality reads a personality row selected with personalityColumns
+func scanPersonality(row interface{ Scan(...interface{}) error }) (*models.Personality, error) {
+	var p models.Personality
+	var bio, lore, knowledge, style, adjectives, tools, params []byte
+	err := row.Scan(&p.ID, &p.Name, &p.Provider, &p.Description, &p.System,
+		&bio, &lore, &knowledge, &style, &adjectives, &p.Instructions, &tools, &p.PromptTemplate, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []struct {
+		data   []byte
+		target interface{}
+	}{
+		{bio, &p.Bio}, {lore, &p.Lore}, {knowledge, &p.Knowledge},
+		{style, &p.Style}, {adjectives, &p.Adjectives}, {tools, &p.Tools},
+		{params, &p.ModelParams},
+	}
+	for _, field := range fields {
+		if err := json.Unmarshal(field.data, field.target); err != nil {
+			return nil, fmt.Errorf("error decoding personality %s: %w", p.ID, err)
+		}
+	}
+	return &p, nil
+}
+
+// Create saves a new personality
+func (postgresPersonalityStore) Create(personality *models.Personality) error {
+	args, err := personalityArgs(personality)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO personalities (id, name, provider, description, system, bio, lore, knowledge, style, adjectives, instructions, tools, prompt_template, model_params)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+	if _, err := database.Exec(query, args...); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return fmt.Errorf("error saving personality: %w", err)
+	}
+	return nil
+}
+
+// GetByID retrieves a personality by its ID
+func (postgresPersonalityStore) GetByID(id string) (*models.Personality, error) {
+	query := `SELECT ` + personalityColumns + ` FROM personalities WHERE id = $1`
+
+	personality, err := scanPersonality(database.GetDB().QueryRow(query, id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return personality, nil
+}
+
+// List returns all personalities ordered by ID
+func (postgresPersonalityStore) List() ([]models.Personality, error) {
+	rows, err := database.GetDB().Query(`SELECT ` + personalityColumns + ` FROM personalities ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying personalities: %w", err)
+	}
+	defer rows.Close()
+
+	var personalities []models.Personality
+	for rows.Next() {
+		personality, err := scanPersonality(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning personality row: %w", err)
+		}
+		personalities = append(personalities, *personality)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating personality rows: %w", err)
+	}
+
+	return personalities, nil
+}
+
+// Update replaces a stored personality
+func (postgresPersonalityStore) Update(personality *models.Personality) error {
+	args, err := personalityArgs(personality)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE personalities
+		SET name = $2, provider = $3, description = $4, system = $5, bio = $6, lore = $7,
+			knowledge = $8, style = $9, adjectives = $10, instructions = $11, tools = $12,
+			prompt_template = $13, model_params = $14, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+	result, err := database.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating personality: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes a personality
+func (postgresPersonalityStore) Delete(id string) error {
+	result, err := database.Exec(`DELETE FROM personalities WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting personality: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
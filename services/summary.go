@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSummaryTokenBudget is how many tokens of history may fall out of the recent
+// window before the summary is regenerated. Override with SUMMARY_TOKEN_BUDGET.
+const DefaultSummaryTokenBudget = 1500
+
+// summaryPrompt instructs the model how to fold new messages into the running summary
+const summaryPrompt = `You maintain the long-term memory of an AI persona. Update the running summary of its conversation with the new messages.
+Keep names, facts about the user, decisions, commitments, dates and open questions. Drop small talk.
+Write in the third person, at most 250 words. Reply with the updated summary only.`
+
+// Summary is the rolling summary of the history of an agent or conversation
+type Summary struct {
+	Content          string    `json:"summary"`
+	CoveredThroughID int       `json:"covered_through_id"` // Last message folded into the summary
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// summarizing tracks scopes with a summary update in flight
+var (
+	summarizingMu sync.Mutex
+	summarizing   = make(map[HistoryScope]bool)
+)
+
+// summaryStore returns the summary store this history works on
+func (ch *ChatHistory) summaryStore() SummaryStore {
+	if ch.summaries != nil {
+		return ch.summaries
+	}
+	return stores.Summaries
+}
+
+// summaryBudget returns the token budget that triggers a summary update
+func (ch *ChatHistory) summaryBudget() int {
+	if ch.summaryTokenBudget > 0 {
+		return ch.summaryTokenBudget
+	}
+	if value, err := strconv.Atoi(os.Getenv("SUMMARY_TOKEN_BUDGET")); err == nil && value > 0 {
+		return value
+	}
+	return DefaultSummaryTokenBudget
+}
+
+// GetSummary returns the summary of everything before the recent window of the scope,
+// or an empty string if the history is still short enough to be sent in full
+func (ch *ChatHistory) GetSummary(scope HistoryScope) string {
+	summary, err := ch.summaryStore().Get(scope)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Printf("Error getting summary: %v", err)
+		}
+		return ""
+	}
+	return summary.Content
+}
+
+// UpdateSummaryAsync starts UpdateSummary in the background unless one is already
+// running for the scope
+func (ch *ChatHistory) UpdateSummaryAsync(scope HistoryScope, provider ChatProvider) {
+	summarizingMu.Lock()
+	if summarizing[scope] {
+		summarizingMu.Unlock()
+		return
+	}
+	summarizing[scope] = true
+	summarizingMu.Unlock()
+
+	go func() {
+		defer func() {
+			summarizingMu.Lock()
+			delete(summarizing, scope)
+			summarizingMu.Unlock()
+		}()
+
+		if err := ch.UpdateSummary(scope, provider); err != nil {
+			log.Printf("Warning: Could not update summary for agent %d: %v", scope.AgentID, err)
+		}
+	}()
+}
+
+// UpdateSummary folds messages that dropped out of the recent window into the summary
+// once they exceed the token budget. Messages still in the window are left alone since
+// they are sent to the model verbatim.
+func (ch *ChatHistory) UpdateSummary(scope HistoryScope, provider ChatProvider) error {
+	summary, err := ch.summaryStore().Get(scope)
+	if errors.Is(err, ErrNotFound) {
+		summary, err = &Summary{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	unsummarized, err := ch.messageStore().Since(scope, summary.CoveredThroughID)
+	if err != nil {
+		return err
+	}
+	if len(unsummarized) <= ch.contextSize {
+		return nil
+	}
+
+	// Only the messages older than the recent window are summarized
+	older := unsummarized[:len(unsummarized)-ch.contextSize]
+	tokens := 0
+	for _, msg := range older {
+		tokens += CountTokens(msg.Content)
+	}
+	if tokens < ch.summaryBudget() {
+		return nil
+	}
+
+	startTime := time.Now()
+	var transcript strings.Builder
+	for _, msg := range older {
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+
+	previous := summary.Content
+	if previous == "" {
+		previous = "(none yet)"
+	}
+
+	completion, err := provider.Complete(context.Background(), CompletionRequest{
+		Messages: []ChatMessage{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: "Current summary:\n" + previous + "\n\nNew messages:\n" + transcript.String()},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error generating summary: %w", err)
+	}
+
+	updated := &Summary{
+		Content:          strings.TrimSpace(completion.Content),
+		CoveredThroughID: older[len(older)-1].ID,
+	}
+	if err := ch.summaryStore().Save(scope, updated); err != nil {
+		return err
+	}
+
+	log.Printf("Summarized %d messages (~%d tokens) for agent %d in %v", len(older), tokens, scope.AgentID, time.Since(startTime))
+	return nil
+}
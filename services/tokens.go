@@ -0,0 +1,158 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultContextWindow is assumed for models missing from modelContextWindows
+const DefaultContextWindow = 8192
+
+// messageTokenOverhead is the per-message framing cost (role markers and separators)
+// used by OpenAI-style chat formats, and replyPrimingTokens primes the assistant reply
+const (
+	messageTokenOverhead = 4
+	replyPrimingTokens   = 3
+)
+
+// modelContextWindows maps model name prefixes to their context window in tokens.
+// Longer prefixes are matched first, so "gpt-4o" wins over "gpt-4".
+var modelContextWindows = map[string]int{
+	"gpt-3.5-turbo":   16385,
+	"gpt-4":           8192,
+	"gpt-4-32k":       32768,
+	"gpt-4-turbo":     128000,
+	"gpt-4o":          128000,
+	"gpt-4.1":         1047576,
+	"o1":              200000,
+	"o3":              200000,
+	"claude-3":        200000,
+	"claude-3-5":      200000,
+	"claude-3-7":      200000,
+	"claude-sonnet-4": 200000,
+	"claude-opus-4":   200000,
+	"grok-beta":       131072,
+	"grok-2":          131072,
+	"grok-3":          131072,
+	"llama3":          8192,
+	"llama3.1":        131072,
+	"llama3.2":        131072,
+	"mistral":         32768,
+	"mixtral":         32768,
+	"qwen2.5":         32768,
+	"gemma2":          8192,
+	"phi3":            4096,
+	"deepseek-r1":     131072,
+}
+
+// ModelContextWindow returns the context window of a model in tokens.
+// MODEL_CONTEXT_WINDOW overrides the lookup, e.g. for local models served with a custom size.
+func ModelContextWindow(model string) int {
+	if value, err := strconv.Atoi(os.Getenv("MODEL_CONTEXT_WINDOW")); err == nil && value > 0 {
+		return value
+	}
+
+	best, window := "", DefaultContextWindow
+	name := strings.ToLower(model)
+	for prefix, size := range modelContextWindows {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(best) {
+			best, window = prefix, size
+		}
+	}
+	return window
+}
+
+// Tokenizer counts the tokens of a text for budgeting purposes
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// tokenPattern splits text the way byte-pair encoders pre-tokenize it: contractions,
+// words with their leading space, runs of up to three digits, punctuation and whitespace
+var tokenPattern = regexp.MustCompile(`'(?:s|t|re|ve|m|ll|d)| ?\pL+| ?\pN{1,3}| ?[^\s\pL\pN]+|\s+`)
+
+// approximateTokenizer estimates BPE token counts without shipping a vocabulary.
+// Common words are one token, long words are split every four characters and
+// non-ASCII text costs roughly a token per character. The counts are estimates for
+// budgeting, not exact counts for any particular vocabulary.
+type approximateTokenizer struct{}
+
+// CountTokens estimates the number of tokens in text
+func (approximateTokenizer) CountTokens(text string) int {
+	count := 0
+	for _, piece := range tokenPattern.FindAllString(text, -1) {
+		word := strings.TrimPrefix(piece, " ")
+		switch {
+		case strings.TrimSpace(piece) == "":
+			count++
+		case utf8.RuneCountInString(word) != len(word):
+			count += utf8.RuneCountInString(word)
+		default:
+			count += (len(word) + 3) / 4
+		}
+	}
+	return count
+}
+
+// DefaultTokenizer is used by CountTokens
+var DefaultTokenizer Tokenizer = approximateTokenizer{}
+
+// CountTokens estimates the number of tokens in text with the default tokenizer
+func CountTokens(text string) int {
+	return DefaultTokenizer.CountTokens(text)
+}
+
+// CountMessageTokens estimates the prompt size of a message list, including the
+// per-message framing overhead
+func CountMessageTokens(messages []ChatMessage) int {
+	total := replyPrimingTokens
+	for _, msg := range messages {
+		total += messageTokenOverhead + CountTokens(msg.Content)
+		for _, call := range msg.ToolCalls {
+			total += CountTokens(call.Name) + CountTokens(call.Arguments)
+		}
+	}
+	return total
+}
+
+// countJSONTokens estimates the tokens taken by a JSON-encoded value, such as tool definitions
+func countJSONTokens(value interface{}) int {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return CountTokens(string(data))
+}
+
+// TruncateToTokens shortens text to at most maxTokens tokens, cutting at a word boundary
+// where possible and marking the cut with an ellipsis
+func TruncateToTokens(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	if CountTokens(text) <= maxTokens {
+		return text
+	}
+
+	// Binary search for the longest rune prefix that fits
+	runes := []rune(text)
+	low, high := 0, len(runes)
+	for low < high {
+		mid := (low + high + 1) / 2
+		if CountTokens(string(runes[:mid]))+1 <= maxTokens {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	truncated := string(runes[:low])
+	if cut := strings.LastIndexAny(truncated, " \n"); cut > len(truncated)/2 {
+		truncated = truncated[:cut]
+	}
+	return truncated + "…"
+}
@@ -0,0 +1,32 @@
+package services
+
+import "testing"
+
+func TestModelContextWindow(t *testing.T) {
+	t.Setenv("MODEL_CONTEXT_WINDOW", "")
+
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4", 8192},
+		{"gpt-4o-mini", 128000},
+		{"gpt-4-turbo-preview", 128000},
+		{"claude-3-5-sonnet-latest", 200000},
+		{"llama3.1:8b", 131072},
+		{"GPT-4O", 128000},
+		{"text-embedding-3-small", DefaultContextWindow},
+		{"unknown-model", DefaultContextWindow},
+	}
+
+	for _, tt := range tests {
+		if got := ModelContextWindow(tt.model); got != tt.want {
+			t.Errorf("ModelContextWindow(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+
+	t.Setenv("MODEL_CONTEXT_WINDOW", "4096")
+	if got := ModelContextWindow("gpt-4o"); got != 4096 {
+		t.Errorf("ModelContextWindow with MODEL_CONTEXT_WINDOW=4096 = %d, want 4096", got)
+	}
+}
@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+func init() {
+	MustRegister(Definition{
+		Name:        "get_current_time",
+		Description: "Returns the current date and time, optionally in a given IANA time zone such as Europe/Berlin.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"timezone": {"type": "string", "description": "IANA time zone name, defaults to UTC"}
+			}
+		}`),
+	}, currentTime)
+
+	MustRegister(Definition{
+		Name:        "random_number",
+		Description: "Returns a random integer between min and max (inclusive).",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"min": {"type": "integer", "description": "Lower bound"},
+				"max": {"type": "integer", "description": "Upper bound"}
+			},
+			"required": ["min", "max"]
+		}`),
+	}, randomNumber)
+}
+
+// currentTime implements the get_current_time tool
+func currentTime(args json.RawMessage) (string, error) {
+	var params struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	location := time.UTC
+	if params.Timezone != "" {
+		loaded, err := time.LoadLocation(params.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("unknown time zone %q", params.Timezone)
+		}
+		location = loaded
+	}
+
+	return time.Now().In(location).Format(time.RFC1123), nil
+}
+
+// randomNumber implements the random_number tool
+func randomNumber(args json.RawMessage) (string, error) {
+	var params struct {
+		Min int `json:"min"`
+		Max int `json:"max"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if params.Max < params.Min {
+		return "", fmt.Errorf("max must not be less than min")
+	}
+	// The span wraps around to a negative value when it does not fit in an int, and
+	// rand.Intn needs span+1 to fit as well
+	span := params.Max - params.Min
+	if span < 0 || span == math.MaxInt {
+		return "", fmt.Errorf("range from %d to %d is too large", params.Min, params.Max)
+	}
+
+	return fmt.Sprintf("%d", params.Min+rand.Intn(span+1)), nil
+}
@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestRandomNumber(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		wantErr  bool
+	}{
+		{"single value", 7, 7, false},
+		{"small range", -3, 3, false},
+		{"max below min", 5, 4, true},
+		{"largest allowed span", 0, math.MaxInt - 1, false},
+		{"span overflows int", math.MinInt, math.MaxInt, true},
+		{"span plus one overflows int", 0, math.MaxInt, true},
+		{"negative to positive overflow", -1, math.MaxInt, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := json.RawMessage(fmt.Sprintf(`{"min": %d, "max": %d}`, tt.min, tt.max))
+			result, err := randomNumber(args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("randomNumber(%d, %d) = %s, want an error", tt.min, tt.max, result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("randomNumber(%d, %d): %v", tt.min, tt.max, err)
+			}
+			value, err := strconv.Atoi(result)
+			if err != nil {
+				t.Fatalf("randomNumber returned %q: %v", result, err)
+			}
+			if value < tt.min || value > tt.max {
+				t.Errorf("randomNumber(%d, %d) = %d, out of range", tt.min, tt.max, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Definition describes a tool to the model: its name, what it does and the
+// JSON schema of the arguments it accepts
+type Definition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// Call is a request from the model to invoke a tool
+type Call struct {
+	ID        string `json:"id"`        // Provider-assigned identifier, echoed back with the result
+	Name      string `json:"name"`      // Name of the registered tool
+	Arguments string `json:"arguments"` // Arguments as a JSON object
+}
+
+// Func implements a tool. It receives the raw JSON arguments sent by the model
+// and returns the result that is fed back to it.
+type Func func(args json.RawMessage) (string, error)
+
+// Tool is a registered tool definition together with its implementation
+type Tool struct {
+	Definition
+	Func Func
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Tool)
+)
+
+// Register adds a tool to the registry. Names must be unique and the parameters
+// must be a valid JSON schema object.
+func Register(def Definition, fn Func) error {
+	if def.Name == "" {
+		return fmt.Errorf("tool name is required")
+	}
+	if fn == nil {
+		return fmt.Errorf("tool %q has no implementation", def.Name)
+	}
+
+	// Tools without arguments still need an (empty) object schema
+	if len(def.Parameters) == 0 {
+		def.Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(def.Parameters, &schema); err != nil {
+		return fmt.Errorf("tool %q has invalid parameter schema: %w", def.Name, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := registry[def.Name]; exists {
+		return fmt.Errorf("tool %q is already registered", def.Name)
+	}
+	registry[def.Name] = Tool{Definition: def, Func: fn}
+	return nil
+}
+
+// MustRegister is like Register but panics on error. It is meant for init functions.
+func MustRegister(def Definition, fn Func) {
+	if err := Register(def, fn); err != nil {
+		panic(err)
+	}
+}
+
+// Get returns the tool registered under name
+func Get(name string) (Tool, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	tool, ok := registry[name]
+	return tool, ok
+}
+
+// List returns the definitions of all registered tools sorted by name
+func List() []Definition {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	definitions := make([]Definition, 0, len(registry))
+	for _, tool := range registry {
+		definitions = append(definitions, tool.Definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Name < definitions[j].Name
+	})
+	return definitions
+}
+
+// Definitions returns the definitions for the named tools, failing on unknown names
+func Definitions(names []string) ([]Definition, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	definitions := make([]Definition, 0, len(names))
+	for _, name := range names {
+		tool, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		definitions = append(definitions, tool.Definition)
+	}
+	return definitions, nil
+}
+
+// Execute runs the tool requested by call. Only tools listed in allowed may run,
+// so a model cannot reach tools its personality was not granted.
+func Execute(call Call, allowed []string) (string, error) {
+	permitted := false
+	for _, name := range allowed {
+		if name == call.Name {
+			permitted = true
+			break
+		}
+	}
+	if !permitted {
+		return "", fmt.Errorf("tool %q is not available to this agent", call.Name)
+	}
+
+	tool, ok := Get(call.Name)
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", call.Name)
+	}
+
+	args := json.RawMessage(call.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	return tool.Func(args)
+}
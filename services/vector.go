@@ -0,0 +1,141 @@
+package services
+
+import (
+	"container/heap"
+	"math"
+)
+
+// DotProduct returns the dot product of two vectors, or 0 if their lengths differ
+func DotProduct(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return float32(sum)
+}
+
+// L2Norm returns the Euclidean length of a vector
+func L2Norm(v []float32) float32 {
+	var sum float64
+	for _, value := range v {
+		sum += float64(value) * float64(value)
+	}
+	return float32(math.Sqrt(sum))
+}
+
+// L2Distance returns the Euclidean distance between two vectors, or +Inf if their lengths differ
+func L2Distance(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return float32(math.Inf(1))
+	}
+
+	var sum float64
+	for i := range a {
+		diff := float64(a[i]) - float64(b[i])
+		sum += diff * diff
+	}
+	return float32(math.Sqrt(sum))
+}
+
+// CosineSimilarity calculates the cosine similarity between two embedding vectors.
+// It returns 0 if the lengths differ or either vector is all zeros.
+func CosineSimilarity(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dotProduct, normA, normB float64
+	for i := range a {
+		dotProduct += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return float32(dotProduct / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
+
+// Normalize returns a copy of v scaled to unit length. A zero vector is returned unchanged.
+func Normalize(v []float32) []float32 {
+	normalized := make([]float32, len(v))
+	copy(normalized, v)
+	NormalizeInPlace(normalized)
+	return normalized
+}
+
+// NormalizeInPlace scales v to unit length. A zero vector is left unchanged.
+func NormalizeInPlace(v []float32) {
+	norm := L2Norm(v)
+	if norm == 0 {
+		return
+	}
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// VectorMatch is a candidate selected by TopK: its position in the candidate list and its score
+type VectorMatch struct {
+	Index int
+	Score float32
+}
+
+// TopK returns the k candidates most similar to query by cosine similarity, best first.
+// Candidates with a different dimension are skipped. Ties keep candidate order.
+func TopK(query []float32, candidates [][]float32, k int) []VectorMatch {
+	if k <= 0 {
+		return nil
+	}
+
+	// Keep the best k seen so far in a min-heap, so the weakest is evicted first
+	h := &matchHeap{}
+	for i, candidate := range candidates {
+		if len(candidate) != len(query) {
+			continue
+		}
+		match := VectorMatch{Index: i, Score: CosineSimilarity(query, candidate)}
+		if h.Len() < k {
+			heap.Push(h, match)
+		} else if h.less(h.matches[0], match) {
+			h.matches[0] = match
+			heap.Fix(h, 0)
+		}
+	}
+
+	// Pop weakest first and fill the result from the back
+	matches := make([]VectorMatch, h.Len())
+	for i := len(matches) - 1; i >= 0; i-- {
+		matches[i] = heap.Pop(h).(VectorMatch)
+	}
+	return matches
+}
+
+// matchHeap is a min-heap of matches ordered by score, with later candidates
+// treated as weaker on equal scores
+type matchHeap struct {
+	matches []VectorMatch
+}
+
+func (h *matchHeap) less(a, b VectorMatch) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	return a.Index > b.Index
+}
+
+func (h *matchHeap) Len() int           { return len(h.matches) }
+func (h *matchHeap) Less(i, j int) bool { return h.less(h.matches[i], h.matches[j]) }
+func (h *matchHeap) Swap(i, j int)      { h.matches[i], h.matches[j] = h.matches[j], h.matches[i] }
+func (h *matchHeap) Push(x interface{}) { h.matches = append(h.matches, x.(VectorMatch)) }
+func (h *matchHeap) Pop() interface{} {
+	last := h.matches[len(h.matches)-1]
+	h.matches = h.matches[:len(h.matches)-1]
+	return last
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+const vectorTolerance = 1e-6
+
+func approxEqual(a, b float32) bool {
+	if math.IsInf(float64(a), 0) || math.IsInf(float64(b), 0) {
+		return a == b
+	}
+	return math.Abs(float64(a-b)) <= vectorTolerance
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float32
+	}{
+		{"identical", []float32{1, 2, 3}, []float32{1, 2, 3}, 1},
+		{"scaled", []float32{1, 2, 3}, []float32{2, 4, 6}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 2}, []float32{-1, -2}, -1},
+		{"45 degrees", []float32{1, 0}, []float32{1, 1}, float32(1 / math.Sqrt2)},
+		{"zero vector", []float32{0, 0, 0}, []float32{1, 2, 3}, 0},
+		{"both zero", []float32{0, 0}, []float32{0, 0}, 0},
+		{"length mismatch", []float32{1, 2}, []float32{1, 2, 3}, 0},
+		{"empty", []float32{}, []float32{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CosineSimilarity(tt.a, tt.b); !approxEqual(got, tt.want) {
+				t.Errorf("CosineSimilarity(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDotProduct(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float32
+	}{
+		{"positive", []float32{1, 2, 3}, []float32{4, 5, 6}, 32},
+		{"mixed signs", []float32{1, -2}, []float32{3, 4}, -5},
+		{"zero vector", []float32{0, 0}, []float32{3, 4}, 0},
+		{"length mismatch", []float32{1, 2}, []float32{1}, 0},
+		{"empty", nil, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DotProduct(tt.a, tt.b); !approxEqual(got, tt.want) {
+				t.Errorf("DotProduct(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestL2Distance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float32
+	}{
+		{"same point", []float32{1, 2}, []float32{1, 2}, 0},
+		{"3-4-5", []float32{0, 0}, []float32{3, 4}, 5},
+		{"negative", []float32{-1, -1}, []float32{2, 3}, 5},
+		{"zero vectors", []float32{0, 0}, []float32{0, 0}, 0},
+		{"length mismatch", []float32{1}, []float32{1, 2}, float32(math.Inf(1))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := L2Distance(tt.a, tt.b); !approxEqual(got, tt.want) {
+				t.Errorf("L2Distance(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		v    []float32
+		want []float32
+	}{
+		{"3-4", []float32{3, 4}, []float32{0.6, 0.8}},
+		{"unit", []float32{0, 1, 0}, []float32{0, 1, 0}},
+		{"negative", []float32{-2, 0}, []float32{-1, 0}},
+		{"zero vector", []float32{0, 0}, []float32{0, 0}},
+		{"empty", []float32{}, []float32{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append([]float32(nil), tt.v...)
+			got := Normalize(input)
+			assertVector(t, "Normalize", got, tt.want)
+			assertVector(t, "Normalize input", input, tt.v)
+
+			NormalizeInPlace(input)
+			assertVector(t, "NormalizeInPlace", input, tt.want)
+		})
+	}
+}
+
+func assertVector(t *testing.T, name string, got, want []float32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %v, want %v", name, got, want)
+	}
+	for i := range want {
+		if !approxEqual(got[i], want[i]) {
+			t.Fatalf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestTopK(t *testing.T) {
+	query := []float32{1, 0}
+	candidates := [][]float32{
+		{0, 1},     // 0: orthogonal
+		{1, 0},     // 1: identical
+		{1, 1},     // 2: 45 degrees
+		{2, 0},     // 3: identical direction, ties with 1
+		{1, 0, 0},  // 4: wrong dimension
+		{-1, 0},    // 5: opposite
+		{0, 0},     // 6: zero vector
+		{1, 1},     // 7: ties with 2
+		{0.5, 0.5}, // 8: ties with 2 and 7
+	}
+
+	tests := []struct {
+		name string
+		k    int
+		want []int
+	}{
+		{"k zero", 0, nil},
+		{"k negative", -1, nil},
+		{"best only", 1, []int{1}},
+		{"ties keep candidate order", 2, []int{1, 3}},
+		{"ties across the cut", 4, []int{1, 3, 2, 7}},
+		{"k larger than n", 20, []int{1, 3, 2, 7, 8, 0, 6, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := TopK(query, candidates, tt.k)
+			var got []int
+			for i, match := range matches {
+				got = append(got, match.Index)
+				if i > 0 && match.Score > matches[i-1].Score {
+					t.Errorf("matches not sorted by score: %v", matches)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TopK(k=%d) = %v, want %v", tt.k, got, tt.want)
+			}
+		})
+	}
+
+	if matches := TopK(query, nil, 3); len(matches) != 0 {
+		t.Errorf("TopK with no candidates = %v, want none", matches)
+	}
+}
+
+func randomVectors(n, dimension int) [][]float32 {
+	r := rand.New(rand.NewSource(1))
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dimension)
+		for j := range vectors[i] {
+			vectors[i][j] = r.Float32()*2 - 1
+		}
+	}
+	return vectors
+}
+
+func BenchmarkCosineSimilarity(b *testing.B) {
+	vectors := randomVectors(2, 1536)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CosineSimilarity(vectors[0], vectors[1])
+	}
+}
+
+func BenchmarkTopK(b *testing.B) {
+	candidates := randomVectors(10000, 1536)
+	query := randomVectors(1, 1536)[0]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		TopK(query, candidates, 10)
+	}
+}